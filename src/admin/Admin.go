@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"ShadowPlayer/src/data"
+	spnet "ShadowPlayer/src/net"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Admin struct {
+	server     *spnet.Server
+	httpServer *http.Server
+}
+
+func New(server *spnet.Server) *Admin {
+	a := &Admin{server: server}
+	a.httpServer = &http.Server{
+		Handler:           a.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return a
+}
+
+// Start 在配置的地址上启动管理接口，未启用时不做任何事
+func (a *Admin) Start() error {
+	config := data.Get().Admin
+	if !config.Enabled {
+		return nil
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("管理接口已启动", "listen", listener.Addr().String())
+	go func() {
+		if err := a.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("管理接口异常退出", "err", err)
+		}
+	}()
+	return nil
+}
+
+func (a *Admin) Shutdown(ctx context.Context) error {
+	return a.httpServer.Shutdown(ctx)
+}
+
+func (a *Admin) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/sessions", a.handleSessions)
+	mux.HandleFunc("GET /api/sessions/{id}", a.handleSession)
+	mux.HandleFunc("POST /api/sessions/{id}/kick", a.handleKick)
+	mux.HandleFunc("POST /api/sessions/{id}/message", a.handleMessage)
+	mux.HandleFunc("POST /api/broadcast", a.handleBroadcast)
+	mux.HandleFunc("GET /api/config", a.handleConfig)
+	mux.HandleFunc("GET /api/capture", a.handleCapturePlayers)
+	mux.HandleFunc("PUT /api/capture/{player}", a.handleSetCapture)
+	mux.HandleFunc("DELETE /api/capture/{player}", a.handleSetCapture)
+	return a.authenticate(mux)
+}
+
+func (a *Admin) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := data.Get().Admin.Token
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "未授权")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (a *Admin) handleSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.Sessions())
+}
+
+func (a *Admin) handleSession(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.server.Session(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "会话不存在")
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+type kickRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (a *Admin) handleKick(w http.ResponseWriter, r *http.Request) {
+	var req kickRequest
+	if r.ContentLength != 0 && !decodeBody(w, r, &req) {
+		return
+	}
+	id := r.PathValue("id")
+	if !a.server.Kick(id, req.Reason) {
+		writeError(w, http.StatusNotFound, "会话不存在")
+		return
+	}
+	slog.Info("管理接口踢出会话", "session", id, "reason", req.Reason)
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+type messageRequest struct {
+	Message string `json:"message"`
+}
+
+func (a *Admin) handleMessage(w http.ResponseWriter, r *http.Request) {
+	var req messageRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "message 不能为空")
+		return
+	}
+	if !a.server.SendSystemMessage(r.PathValue("id"), req.Message) {
+		writeError(w, http.StatusNotFound, "会话不存在或发送失败")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+func (a *Admin) handleBroadcast(w http.ResponseWriter, r *http.Request) {
+	var req messageRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "message 不能为空")
+		return
+	}
+	sent := a.server.Broadcast(req.Message)
+	writeJSON(w, http.StatusOK, map[string]int{"sent": sent})
+}
+
+func (a *Admin) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, data.Get().Redacted())
+}
+
+func (a *Admin) handleCapturePlayers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"players": a.server.CapturePlayers()})
+}
+
+// handleSetCapture PUT 开启、DELETE 关闭指定玩家的抓包
+func (a *Admin) handleSetCapture(w http.ResponseWriter, r *http.Request) {
+	player := r.PathValue("player")
+	enabled := r.Method == http.MethodPut
+	active, err := a.server.SetCapture(player, enabled)
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	slog.Info("管理接口设置玩家抓包", "player", player, "enabled", enabled)
+	writeJSON(w, http.StatusOK, map[string]bool{"enabled": enabled, "active": active})
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "请求体无效: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
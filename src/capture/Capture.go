@@ -0,0 +1,198 @@
+package capture
+
+import (
+	_type "ShadowPlayer/src/type"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Direction 表示抓包点。客户端一侧记录客户端发来的原始帧和改写后发给客户端的帧，
+// 目标一侧记录目标服务器发来的原始帧和改写后发往目标服务器的帧
+type Direction int
+
+const (
+	ClientToProxy Direction = iota
+	ProxyToClient
+	TargetToProxy
+	ProxyToTarget
+)
+
+func (d Direction) String() string {
+	switch d {
+	case ClientToProxy:
+		return "client->sp"
+	case ProxyToClient:
+		return "sp->client"
+	case TargetToProxy:
+		return "target->sp"
+	case ProxyToTarget:
+		return "sp->target"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+// DirectionOf 根据虚拟地址还原抓包点
+func DirectionOf(src netip.Addr, dst netip.Addr) (Direction, bool) {
+	switch {
+	case src == ClientAddr && dst == ProxyAddr:
+		return ClientToProxy, true
+	case src == ProxyAddr && dst == ClientAddr:
+		return ProxyToClient, true
+	case src == TargetAddr && dst == ProxyAddr:
+		return TargetToProxy, true
+	case src == ProxyAddr && dst == TargetAddr:
+		return ProxyToTarget, true
+	}
+	return 0, false
+}
+
+// Session 把一个会话的所有帧写入 pcapng 文件。写入失败后不再记录，错误由 Close 返回
+type Session struct {
+	mu         sync.Mutex
+	file       *os.File
+	path       string
+	bw         *bufio.Writer
+	pw         *pcapngWriter
+	ipID       uint16
+	clientLeg  tcpFlow
+	targetLeg  tcpFlow
+	targetLegs int
+	comment    string
+	err        error
+}
+
+// Create 在 dir 下创建抓包文件。clientPort 与 listenPort 用作客户端一侧虚拟连接的端口，
+// comment 会写在该连接的握手包上
+func Create(dir string, name string, clientPort uint16, listenPort uint16, comment string) (*Session, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s.pcapng", time.Now().Format("20060102-150405"), name))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(file)
+	pw, err := newPcapngWriter(bw, "ShadowPlayer", "shadowplayer", linkTypeRaw)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &Session{
+		file:      file,
+		path:      path,
+		bw:        bw,
+		pw:        pw,
+		clientLeg: newFlow(ClientAddr, clientPort, ProxyAddr, listenPort),
+		comment:   comment,
+	}, nil
+}
+
+func (s *Session) Path() string {
+	return s.path
+}
+
+// SetTarget 开始一条新的目标一侧虚拟连接，之前的连接会以 FIN 结束
+func (s *Session) SetTarget(targetPort uint16, comment string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.file == nil {
+		return
+	}
+	now := time.Now()
+	if s.targetLeg.open {
+		s.writeSegments(now, s.targetLeg.fin(&s.ipID), "")
+	}
+	s.targetLegs++
+	s.targetLeg = newFlow(ProxyAddr, uint16(proxyEgressPort+s.targetLegs), TargetAddr, targetPort)
+	s.writeSegments(now, s.targetLeg.handshake(&s.ipID), comment)
+}
+
+// Record 记录一个数据包，包头按线上格式补全
+func (s *Session) Record(dir Direction, packet _type.Packet) {
+	frame := make([]byte, 8+len(packet.Bytes))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(packet.Bytes)))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(packet.Type))
+	copy(frame[8:], packet.Bytes)
+	s.RecordFrame(dir, frame)
+}
+
+// RecordFrame 记录一个完整的帧（4 字节长度、4 字节类型和内容）
+func (s *Session) RecordFrame(dir Direction, frame []byte) {
+	comment := dir.String()
+	if len(frame) >= 8 {
+		comment += " " + _type.PacketType(binary.BigEndian.Uint32(frame[4:8])).String()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.file == nil {
+		return
+	}
+	now := time.Now()
+
+	var flow *tcpFlow
+	var fromLocal bool
+	switch dir {
+	case ClientToProxy, ProxyToClient:
+		flow, fromLocal = &s.clientLeg, dir == ClientToProxy
+		if !flow.open {
+			s.writeSegments(now, flow.handshake(&s.ipID), s.comment)
+		}
+	case TargetToProxy, ProxyToTarget:
+		flow, fromLocal = &s.targetLeg, dir == ProxyToTarget
+		if !flow.open {
+			// 未调用 SetTarget 时使用未知端口 0
+			s.targetLegs++
+			*flow = newFlow(ProxyAddr, uint16(proxyEgressPort+s.targetLegs), TargetAddr, flow.remote.port)
+			s.writeSegments(now, flow.handshake(&s.ipID), "")
+		}
+	default:
+		return
+	}
+	s.writeSegments(now, flow.data(&s.ipID, fromLocal, frame), comment)
+}
+
+// writeSegments 写出报文段，注释只附加在第一个报文段上
+func (s *Session) writeSegments(ts time.Time, segments [][]byte, comment string) {
+	for i, segment := range segments {
+		if s.err != nil {
+			return
+		}
+		if i > 0 {
+			comment = ""
+		}
+		s.err = s.pw.writePacket(ts, segment, comment)
+	}
+	if s.err == nil {
+		s.err = s.bw.Flush()
+	}
+}
+
+// Close 结束所有虚拟连接并关闭文件，返回期间遇到的第一个写入错误
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return s.err
+	}
+	now := time.Now()
+	if s.clientLeg.open {
+		s.writeSegments(now, s.clientLeg.fin(&s.ipID), "")
+	}
+	if s.targetLeg.open {
+		s.writeSegments(now, s.targetLeg.fin(&s.ipID), "")
+	}
+	if err := s.file.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	s.file = nil
+	return s.err
+}
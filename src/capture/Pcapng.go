@@ -0,0 +1,105 @@
+package capture
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// pcapng 块类型与选项，见 https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterface      = 0x00000001
+	blockEnhancedPacket = 0x00000006
+	byteOrderMagic      = 0x1A2B3C4D
+	optEndOfOpt         = 0
+	optComment          = 1
+	optShbUserAppl      = 4
+	optIfName           = 2
+	linkTypeRaw         = 101 // 不带链路层头的 IPv4/IPv6 包
+	defaultSnapLen      = 0   // 0 表示不截断
+)
+
+type option struct {
+	code  uint16
+	value []byte
+}
+
+// pcapngWriter 按小端序写出单个 section，只包含一个接口
+type pcapngWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newPcapngWriter(w io.Writer, application string, interfaceName string, linkType uint16) (*pcapngWriter, error) {
+	pw := &pcapngWriter{w: w}
+
+	// Section Header Block：字节序标记、版本 1.0、section 长度未知 (-1)
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:6], 1)
+	binary.LittleEndian.PutUint16(body[6:8], 0)
+	binary.LittleEndian.PutUint64(body[8:16], ^uint64(0))
+	if err := pw.writeBlock(blockSectionHeader, body, []option{{optShbUserAppl, []byte(application)}}); err != nil {
+		return nil, err
+	}
+
+	// Interface Description Block：时间戳精度默认为微秒
+	body = make([]byte, 8)
+	binary.LittleEndian.PutUint16(body[0:2], linkType)
+	binary.LittleEndian.PutUint32(body[4:8], defaultSnapLen)
+	if err := pw.writeBlock(blockInterface, body, []option{{optIfName, []byte(interfaceName)}}); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *pcapngWriter) writePacket(ts time.Time, packet []byte, comment string) error {
+	micros := uint64(ts.UnixMicro())
+	body := make([]byte, 20, 20+len(packet)+3)
+	binary.LittleEndian.PutUint32(body[0:4], 0)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(micros>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(micros))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(packet)))
+	body = append(body, packet...)
+	body = appendPadding(body)
+
+	var options []option
+	if comment != "" {
+		options = append(options, option{optComment, []byte(comment)})
+	}
+	return pw.writeBlock(blockEnhancedPacket, body, options)
+}
+
+// writeBlock 写出一个完整的块：类型、总长度、内容、选项、总长度
+func (pw *pcapngWriter) writeBlock(blockType uint32, body []byte, options []option) error {
+	buf := pw.buf[:0]
+	buf = binary.LittleEndian.AppendUint32(buf, blockType)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = append(buf, body...)
+	if len(options) > 0 {
+		for _, opt := range options {
+			buf = binary.LittleEndian.AppendUint16(buf, opt.code)
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(len(opt.value)))
+			buf = append(buf, opt.value...)
+			buf = appendPadding(buf)
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, optEndOfOpt)
+		buf = binary.LittleEndian.AppendUint16(buf, 0)
+	}
+	total := uint32(len(buf) + 4)
+	binary.LittleEndian.PutUint32(buf[4:8], total)
+	buf = binary.LittleEndian.AppendUint32(buf, total)
+	pw.buf = buf
+
+	_, err := pw.w.Write(buf)
+	return err
+}
+
+func appendPadding(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
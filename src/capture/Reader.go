@@ -0,0 +1,166 @@
+package capture
+
+import (
+	_type "ShadowPlayer/src/type"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"time"
+)
+
+// Frame 是从抓包文件中还原出的一个完整帧
+type Frame struct {
+	Time      time.Time
+	Direction Direction
+	Packet    _type.Packet
+}
+
+type flowKey struct {
+	src, dst         netip.Addr
+	srcPort, dstPort uint16
+}
+
+type flowBuffer struct {
+	nextSeq uint32
+	started bool
+	data    []byte
+}
+
+// ReadFile 读取由 Session 写出的 pcapng 文件，按时间顺序返回所有帧
+func ReadFile(path string) ([]Frame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadFrames(bufio.NewReader(file))
+}
+
+func ReadFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	flows := make(map[flowKey]*flowBuffer)
+	header := make([]byte, 8)
+	first := true
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return frames, nil
+			}
+			return frames, fmt.Errorf("读取块头失败: %w", err)
+		}
+		blockType := binary.LittleEndian.Uint32(header[0:4])
+		blockLen := binary.LittleEndian.Uint32(header[4:8])
+		if first && blockType != blockSectionHeader {
+			return nil, errors.New("不是 pcapng 文件")
+		}
+		if blockLen < 12 || blockLen%4 != 0 {
+			return frames, fmt.Errorf("块长度无效: %d", blockLen)
+		}
+		body := make([]byte, blockLen-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return frames, fmt.Errorf("读取块内容失败: %w", err)
+		}
+
+		switch blockType {
+		case blockSectionHeader:
+			if binary.LittleEndian.Uint32(body[0:4]) != byteOrderMagic {
+				return frames, errors.New("只支持小端序的 pcapng 文件")
+			}
+		case blockInterface:
+			if linkType := binary.LittleEndian.Uint16(body[0:2]); linkType != linkTypeRaw {
+				return frames, fmt.Errorf("不支持的链路类型 %d", linkType)
+			}
+		case blockEnhancedPacket:
+			if len(body) < 24 {
+				return frames, errors.New("数据包块过短")
+			}
+			micros := uint64(binary.LittleEndian.Uint32(body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(body[8:12]))
+			capLen := binary.LittleEndian.Uint32(body[12:16])
+			if int(capLen) > len(body)-20 {
+				return frames, errors.New("数据包长度超出块范围")
+			}
+			ts := time.UnixMicro(int64(micros))
+			frames = appendSegment(frames, flows, ts, body[20:20+capLen])
+		}
+		first = false
+	}
+}
+
+// appendSegment 解析一个虚拟 TCP 报文段，把负载拼接到所属连接，并取出其中完整的帧
+func appendSegment(frames []Frame, flows map[flowKey]*flowBuffer, ts time.Time, packet []byte) []Frame {
+	if len(packet) < ipv4HeaderLen+tcpHeaderLen || packet[0]>>4 != 4 || packet[9] != 6 {
+		return frames
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(packet[2:4]))
+	if total > len(packet) || ihl+tcpHeaderLen > total {
+		return frames
+	}
+	tcp := packet[ihl:total]
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset > len(tcp) {
+		return frames
+	}
+
+	key := flowKey{
+		src:     netip.AddrFrom4([4]byte(packet[12:16])),
+		dst:     netip.AddrFrom4([4]byte(packet[16:20])),
+		srcPort: binary.BigEndian.Uint16(tcp[0:2]),
+		dstPort: binary.BigEndian.Uint16(tcp[2:4]),
+	}
+	dir, ok := DirectionOf(key.src, key.dst)
+	if !ok {
+		return frames
+	}
+
+	seq := binary.BigEndian.Uint32(tcp[4:8])
+	flags := tcp[13]
+	payload := tcp[dataOffset:]
+
+	flow := flows[key]
+	if flow == nil || flags&tcpSyn != 0 {
+		// 新的握手表示一条新连接，丢弃同一地址上残留的半个帧
+		flow = &flowBuffer{}
+		flows[key] = flow
+	}
+	if flags&tcpSyn != 0 {
+		flow.nextSeq = seq + 1
+		flow.started = true
+		return frames
+	}
+	if len(payload) == 0 {
+		return frames
+	}
+	if !flow.started {
+		flow.nextSeq = seq
+		flow.started = true
+	}
+	if seq != flow.nextSeq {
+		// 虚拟连接不会乱序，序列号不连续说明文件被截断或拼接过，从这里重新开始
+		flow.data = flow.data[:0]
+	}
+	flow.nextSeq = seq + uint32(len(payload))
+	flow.data = append(flow.data, payload...)
+
+	for len(flow.data) >= 8 {
+		length := int(binary.BigEndian.Uint32(flow.data[0:4]))
+		if length < 0 || len(flow.data) < 8+length {
+			break
+		}
+		frames = append(frames, Frame{
+			Time:      ts,
+			Direction: dir,
+			Packet: _type.Packet{
+				Type:  _type.PacketType(int32(binary.BigEndian.Uint32(flow.data[4:8]))),
+				Bytes: append([]byte(nil), flow.data[8:8+length]...),
+			},
+		})
+		flow.data = flow.data[8+length:]
+	}
+	return frames
+}
@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// 抓包中使用的虚拟地址。客户端与 ShadowPlayer 之间、ShadowPlayer 与目标服务器之间
+// 各是一条虚拟 TCP 连接，真实地址写在握手包的注释里
+var (
+	ClientAddr = netip.MustParseAddr("10.0.0.1")
+	ProxyAddr  = netip.MustParseAddr("10.0.0.2")
+	TargetAddr = netip.MustParseAddr("10.0.0.3")
+)
+
+const (
+	tcpFin = 0x01
+	tcpSyn = 0x02
+	tcpPsh = 0x08
+	tcpAck = 0x10
+
+	ipv4HeaderLen = 20
+	tcpHeaderLen  = 20
+	// 单个虚拟报文段的最大负载，保证 IPv4 总长度不超过 65535
+	maxSegmentPayload = 65535 - ipv4HeaderLen - tcpHeaderLen
+	proxyEgressPort   = 40000
+)
+
+type endpoint struct {
+	addr netip.Addr
+	port uint16
+	seq  uint32
+}
+
+// tcpFlow 记录一条虚拟连接两端的序列号，local 为主动发起连接的一方
+type tcpFlow struct {
+	local  endpoint
+	remote endpoint
+	open   bool
+}
+
+func newFlow(localAddr netip.Addr, localPort uint16, remoteAddr netip.Addr, remotePort uint16) tcpFlow {
+	return tcpFlow{
+		local:  endpoint{addr: localAddr, port: localPort, seq: 1000},
+		remote: endpoint{addr: remoteAddr, port: remotePort, seq: 5000},
+	}
+}
+
+// ends 根据发送方返回 (发送端, 接收端)
+func (f *tcpFlow) ends(fromLocal bool) (*endpoint, *endpoint) {
+	if fromLocal {
+		return &f.local, &f.remote
+	}
+	return &f.remote, &f.local
+}
+
+// handshake 生成三次握手的三个报文
+func (f *tcpFlow) handshake(ipID *uint16) [][]byte {
+	segments := [][]byte{
+		buildSegment(ipID, &f.local, &f.remote, tcpSyn, nil),
+	}
+	f.local.seq++
+	segments = append(segments, buildSegment(ipID, &f.remote, &f.local, tcpSyn|tcpAck, nil))
+	f.remote.seq++
+	segments = append(segments, buildSegment(ipID, &f.local, &f.remote, tcpAck, nil))
+	f.open = true
+	return segments
+}
+
+// data 把负载切分为若干报文段并推进发送端的序列号
+func (f *tcpFlow) data(ipID *uint16, fromLocal bool, payload []byte) [][]byte {
+	from, to := f.ends(fromLocal)
+	var segments [][]byte
+	for len(payload) > 0 {
+		n := min(len(payload), maxSegmentPayload)
+		segments = append(segments, buildSegment(ipID, from, to, tcpPsh|tcpAck, payload[:n]))
+		from.seq += uint32(n)
+		payload = payload[n:]
+	}
+	return segments
+}
+
+// fin 生成双方各一个 FIN，表示虚拟连接正常关闭
+func (f *tcpFlow) fin(ipID *uint16) [][]byte {
+	segments := [][]byte{buildSegment(ipID, &f.local, &f.remote, tcpFin|tcpAck, nil)}
+	f.local.seq++
+	segments = append(segments, buildSegment(ipID, &f.remote, &f.local, tcpFin|tcpAck, nil))
+	f.remote.seq++
+	f.open = false
+	return segments
+}
+
+func buildSegment(ipID *uint16, from *endpoint, to *endpoint, flags byte, payload []byte) []byte {
+	total := ipv4HeaderLen + tcpHeaderLen + len(payload)
+	packet := make([]byte, total)
+
+	ip := packet[:ipv4HeaderLen]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:4], uint16(total))
+	*ipID++
+	binary.BigEndian.PutUint16(ip[4:6], *ipID)
+	binary.BigEndian.PutUint16(ip[6:8], 0x4000) // DF
+	ip[8] = 64
+	ip[9] = 6 // TCP
+	src, dst := from.addr.As4(), to.addr.As4()
+	copy(ip[12:16], src[:])
+	copy(ip[16:20], dst[:])
+	binary.BigEndian.PutUint16(ip[10:12], checksum(ip, 0))
+
+	tcp := packet[ipv4HeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:2], from.port)
+	binary.BigEndian.PutUint16(tcp[2:4], to.port)
+	binary.BigEndian.PutUint32(tcp[4:8], from.seq)
+	if flags&tcpAck != 0 {
+		binary.BigEndian.PutUint32(tcp[8:12], to.seq)
+	}
+	tcp[12] = (tcpHeaderLen / 4) << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 65535)
+	copy(tcp[tcpHeaderLen:], payload)
+
+	// 伪首部：源地址、目的地址、协议号、TCP 长度
+	var pseudo uint32
+	pseudo += uint32(binary.BigEndian.Uint16(src[0:2])) + uint32(binary.BigEndian.Uint16(src[2:4]))
+	pseudo += uint32(binary.BigEndian.Uint16(dst[0:2])) + uint32(binary.BigEndian.Uint16(dst[2:4]))
+	pseudo += 6 + uint32(len(tcp))
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(tcp, pseudo))
+	return packet
+}
+
+// checksum 计算互联网校验和，initial 为已经累加的伪首部
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
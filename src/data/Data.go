@@ -2,56 +2,639 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
 )
 
-var GlobalConfig, _ = fetchConfig()
-
 type Config struct {
-	Port int32 `json:"port"`
+	ListenAddress     string   `json:"listenAddress"`
+	Port              int32    `json:"port"`
+	MaxConnections    int      `json:"maxConnections"`
+	MaxMessageSize    int32    `json:"maxMessageSize"`
+	ReadTimeout       Duration `json:"readTimeout"`
+	WriteTimeout      Duration `json:"writeTimeout"`
+	ProxyDialTimeout  Duration `json:"proxyDialTimeout"` // 每次连接目标服务器（含解析）的超时
+	ProxyReadTimeout  Duration `json:"proxyReadTimeout"`
+	ProxyWriteTimeout Duration `json:"proxyWriteTimeout"`
+	DefaultTargetPort int32    `json:"defaultTargetPort"`
+	TraceURL          string   `json:"traceUrl"`
+	ShutdownGrace     Duration `json:"shutdownGrace"` // 关闭服务器时等待玩家结束对局的时间
+	PingInterval      Duration `json:"pingInterval"`  // 主动向玩家发送心跳并采样 RTT 的间隔，为 0 时不主动探测
+
+	Targets           []TargetServer `json:"targets"`           // 欢迎对话框中列出的目标服务器
+	AllowCustomTarget bool           `json:"allowCustomTarget"` // 是否允许玩家手动输入 IP:端口
+
+	DestinationPolicy DestinationPolicy `json:"destinationPolicy"`
+	Upstreams         []UpstreamProxy   `json:"upstreams"` // 经其他代理连接部分目标，按顺序匹配，未匹配的目标直接连接
+	Egress            EgressConfig      `json:"egress"`
+
+	Hosts       map[string][]string `json:"hosts"`       // 静态主机表，优先于 DNS 解析
+	DNSCacheTTL Duration            `json:"dnsCacheTtl"` // 为 0 时不缓存
+
+	PlayerStore string `json:"playerStore"` // 玩家记录文件，相对路径以配置文件所在目录为准，留空则不记录
+
+	ProxyProtocol ProxyProtocolConfig `json:"proxyProtocol"`
+	Dial          DialConfig          `json:"dial"`
+	Forward       ForwardConfig       `json:"forward"`
+	Commands      CommandConfig       `json:"commands"`
+
+	Log       LogConfig       `json:"log"`
+	Capture   CaptureConfig   `json:"capture"`
+	Admin     AdminConfig     `json:"admin"`
+	Metrics   MetricsConfig   `json:"metrics"`
+	Tunnel    TunnelConfig    `json:"tunnel"`
+	WebSocket WebSocketConfig `json:"webSocket"`
+}
+
+// ProxyProtocolConfig 用于在 TCP 负载均衡或 HAProxy 之后运行，从 PROXY protocol v1/v2 头部读取玩家的真实地址。
+// 只有来自 trustedCidrs 的连接必须携带头部，其他连接按直连处理，头部不会被解析
+type ProxyProtocolConfig struct {
+	Enabled       bool     `json:"enabled"`
+	TrustedCIDRs  []string `json:"trustedCidrs"`
+	HeaderTimeout Duration `json:"headerTimeout"` // 等待头部的最长时间
+}
+
+// DialConfig 控制连接目标服务器失败后的重试，重试间隔从 initialBackoff 开始每次翻倍，不超过 maxBackoff
+type DialConfig struct {
+	Attempts       int      `json:"attempts"` // 总尝试次数，1 表示不重试
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+}
+
+// ForwardConfig 控制客户端发往目标服务器的流量。排队的数据超过 maxBufferedBytes 时暂停读取客户端，
+// 而不是丢弃数据包；暂停超过 stallTimeout 后按 stallPolicy 处理
+type ForwardConfig struct {
+	MaxBufferedBytes int      `json:"maxBufferedBytes"` // 每个连接等待写出的最大字节数，客户端与目标两侧分别计算
+	StallTimeout     Duration `json:"stallTimeout"`
+	StallPolicy      string   `json:"stallPolicy"` // disconnect：通知玩家后断开；wait：记录日志并继续等待
+}
+
+// CommandConfig 控制对局中的聊天指令。以 prefix 开头的聊天由 ShadowPlayer 处理并私下回复，不转发给目标服务器
+type CommandConfig struct {
+	Prefix string          `json:"prefix"` // 留空则不拦截任何聊天
+	Custom []CustomCommand `json:"custom"` // 运营者追加的指令，与内置指令同名时内置指令优先
+}
+
+// CustomCommand 是回复固定文本的指令，例如服务器规则或联系方式
+type CustomCommand struct {
+	Name        string `json:"name"`
+	Description string `json:"description"` // 显示在 help 中
+	Reply       string `json:"reply"`
+}
+
+// CaptureConfig 为指定玩家的会话抓包，保存为 pcapng 文件
+type CaptureConfig struct {
+	Dir     string   `json:"dir"`     // 相对路径以配置文件所在目录为准
+	Players []string `json:"players"` // 需要抓包的玩家名，可通过管理接口临时增减
 }
 
-func fetchConfig() (Config, error) {
-	config := Config{
-		Port: 5123,
+// LogConfig 日志级别与输出格式
+type LogConfig struct {
+	Level      string `json:"level"`      // debug、info、warn、error
+	Format     string `json:"format"`     // text 或 json
+	SessionDir string `json:"sessionDir"` // 非空时为每个会话单独写一份 debug 级别的日志文件
+}
+
+// AdminConfig 管理接口，默认关闭且只监听本机
+type AdminConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+	Token   string `json:"token"` // 请求需携带 Authorization: Bearer <token>
+}
+
+// MetricsConfig 以 Prometheus 文本格式导出运行指标，默认关闭
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen"`
+	Path    string `json:"path"`
+}
+
+// TunnelConfig 接受 shadowplayer client 建立的双向认证 TLS 隧道，一条隧道可以承载多个玩家会话。
+// 证书文件在每次握手时按当前配置读取，热重载后新的隧道使用新证书
+type TunnelConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Listen       string `json:"listen"`
+	CertFile     string `json:"certFile"` // 服务器证书与私钥，相对路径以配置文件所在目录为准
+	KeyFile      string `json:"keyFile"`
+	ClientCAFile string `json:"clientCaFile"` // 只接受由该 CA 签发的客户端证书
+}
+
+// WebSocketConfig 通过 WebSocket 接受游戏会话，供只能访问 HTTP(S) 的玩家使用。
+// 启动时同时设置了 certFile 和 keyFile 则使用 wss，证书在热重载后用于新的连接
+type WebSocketConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Listen   string `json:"listen"`
+	Path     string `json:"path"`
+	CertFile string `json:"certFile"` // 相对路径以配置文件所在目录为准
+	KeyFile  string `json:"keyFile"`
+}
+
+// DestinationPolicy 限制玩家可以代理到的目标地址，对目录中的服务器同样生效
+type DestinationPolicy struct {
+	AllowCIDRs   []string `json:"allowCidrs"` // 非空时只允许这些网段
+	DenyCIDRs    []string `json:"denyCidrs"`  // 优先于 allowCidrs
+	MinPort      int32    `json:"minPort"`    // 允许的端口范围 [minPort, maxPort]
+	MaxPort      int32    `json:"maxPort"`
+	BlockedHosts []string `json:"blockedHosts"` // 禁止的主机名，支持 *.example.com
+}
+
+// UpstreamProxy 是连接目标服务器时经过的一跳代理。目标的主机名匹配 hosts，
+// 或解析后的地址落在 cidrs 内时使用；主机名仍在本地解析并经过 destinationPolicy 检查
+type UpstreamProxy struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`    // socks5 或 http（HTTP CONNECT）
+	Address  string   `json:"address"` // 代理的 host:port
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Hosts    []string `json:"hosts"` // 目标主机名或 IP，支持 *.example.com
+	CIDRs    []string `json:"cidrs"`
+}
+
+// EgressConfig 为直接连接目标服务器的出站连接选择本机源地址，
+// 让不同玩家在目标服务器看来来自不同的 IP
+type EgressConfig struct {
+	Addresses []string `json:"addresses"` // 本机 IP 或网卡名，网卡使用其当前的全部地址；为空时使用系统默认的源地址
+	Strategy  string   `json:"strategy"`  // roundRobin：轮流使用；sticky：同一玩家固定使用同一地址；leastUsed：使用当前连接数最少的地址
+}
+
+type TargetServer struct {
+	Name        string `json:"name"`
+	Host        string `json:"host"`
+	Port        int32  `json:"port"`
+	Description string `json:"description"`
+	Fog         bool   `json:"fog"` // 选择该服务器时默认是否启用去雾
+}
+
+// 修改后必须重启才能生效的字段，热重载时保留旧值
+var restartOnlyFields = []string{"listenAddress", "port", "maxConnections"}
+
+func DefaultConfig() Config {
+	return Config{
+		ListenAddress:     "",
+		Port:              5123,
+		MaxConnections:    1000,
+		MaxMessageSize:    512 * 1024,
+		ReadTimeout:       Duration(30 * time.Second),
+		WriteTimeout:      Duration(30 * time.Second),
+		ProxyDialTimeout:  Duration(10 * time.Second),
+		ProxyReadTimeout:  Duration(30 * time.Second),
+		ProxyWriteTimeout: Duration(30 * time.Second),
+		DefaultTargetPort: 5123,
+		TraceURL:          "https://image.nebulapause.com/cdn-cgi/trace",
+		ShutdownGrace:     Duration(10 * time.Second),
+		PingInterval:      Duration(5 * time.Second),
+		Targets:           []TargetServer{},
+		AllowCustomTarget: true,
+		DestinationPolicy: DestinationPolicy{
+			AllowCIDRs: []string{},
+			DenyCIDRs: []string{
+				"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+				"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+				"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+			},
+			MinPort:      1024,
+			MaxPort:      65535,
+			BlockedHosts: []string{"localhost", "*.localhost", "metadata.google.internal"},
+		},
+		Upstreams:   []UpstreamProxy{},
+		Hosts:       map[string][]string{},
+		DNSCacheTTL: Duration(time.Minute),
+		PlayerStore: "players.json",
+		Egress: EgressConfig{
+			Addresses: []string{},
+			Strategy:  "roundRobin",
+		},
+		ProxyProtocol: ProxyProtocolConfig{
+			Enabled:       false,
+			TrustedCIDRs:  []string{},
+			HeaderTimeout: Duration(5 * time.Second),
+		},
+		Dial: DialConfig{
+			Attempts:       3,
+			InitialBackoff: Duration(time.Second),
+			MaxBackoff:     Duration(5 * time.Second),
+		},
+		Forward: ForwardConfig{
+			MaxBufferedBytes: 1024 * 1024,
+			StallTimeout:     Duration(15 * time.Second),
+			StallPolicy:      "disconnect",
+		},
+		Commands: CommandConfig{
+			Prefix: ".sp",
+			Custom: []CustomCommand{},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Capture: CaptureConfig{
+			Dir:     "captures",
+			Players: []string{},
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Listen:  "127.0.0.1:5124",
+		},
+		Metrics: MetricsConfig{
+			Enabled: false,
+			Listen:  "127.0.0.1:9124",
+			Path:    "/metrics",
+		},
+		Tunnel: TunnelConfig{
+			Enabled: false,
+			Listen:  ":5443",
+		},
+		WebSocket: WebSocketConfig{
+			Enabled: false,
+			Listen:  ":8080",
+			Path:    "/ws",
+		},
 	}
+}
 
+func (c *Config) Validate() error {
+	var errs []error
+	if c.ListenAddress != "" && net.ParseIP(c.ListenAddress) == nil {
+		errs = append(errs, fmt.Errorf("listenAddress 不是有效的IP地址: %q", c.ListenAddress))
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port 必须在 1-65535 之间: %d", c.Port))
+	}
+	if c.MaxConnections <= 0 {
+		errs = append(errs, fmt.Errorf("maxConnections 必须大于 0: %d", c.MaxConnections))
+	}
+	if c.MaxMessageSize < 1024 || c.MaxMessageSize > 64*1024*1024 {
+		errs = append(errs, fmt.Errorf("maxMessageSize 必须在 1KB-64MB 之间: %d", c.MaxMessageSize))
+	}
+	for name, d := range map[string]Duration{
+		"readTimeout":       c.ReadTimeout,
+		"writeTimeout":      c.WriteTimeout,
+		"proxyDialTimeout":  c.ProxyDialTimeout,
+		"proxyReadTimeout":  c.ProxyReadTimeout,
+		"proxyWriteTimeout": c.ProxyWriteTimeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("%s 必须大于 0: %v", name, d))
+		}
+	}
+	if c.ShutdownGrace < 0 {
+		errs = append(errs, fmt.Errorf("shutdownGrace 不能为负数: %v", c.ShutdownGrace))
+	}
+	if c.PingInterval < 0 {
+		errs = append(errs, fmt.Errorf("pingInterval 不能为负数: %v", c.PingInterval))
+	}
+	if c.DefaultTargetPort <= 0 || c.DefaultTargetPort > 65535 {
+		errs = append(errs, fmt.Errorf("defaultTargetPort 必须在 1-65535 之间: %d", c.DefaultTargetPort))
+	}
+	for i, target := range c.Targets {
+		if target.Name == "" {
+			errs = append(errs, fmt.Errorf("targets[%d].name 不能为空", i))
+		}
+		if target.Host == "" {
+			errs = append(errs, fmt.Errorf("targets[%d].host 不能为空", i))
+		}
+		if target.Port <= 0 || target.Port > 65535 {
+			errs = append(errs, fmt.Errorf("targets[%d].port 必须在 1-65535 之间: %d", i, target.Port))
+		}
+	}
+	policy := c.DestinationPolicy
+	for name, cidrs := range map[string][]string{"allowCidrs": policy.AllowCIDRs, "denyCidrs": policy.DenyCIDRs} {
+		for _, cidr := range cidrs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("destinationPolicy.%s 中的网段无效: %q", name, cidr))
+			}
+		}
+	}
+	upstreamNames := make(map[string]bool)
+	for i, upstream := range c.Upstreams {
+		if upstream.Name == "" || upstreamNames[upstream.Name] {
+			errs = append(errs, fmt.Errorf("upstreams[%d].name 不能为空或重复: %q", i, upstream.Name))
+		}
+		upstreamNames[upstream.Name] = true
+		if upstream.Type != "socks5" && upstream.Type != "http" {
+			errs = append(errs, fmt.Errorf("upstreams[%d].type 必须是 socks5 或 http: %q", i, upstream.Type))
+		}
+		if _, _, err := net.SplitHostPort(upstream.Address); err != nil {
+			errs = append(errs, fmt.Errorf("upstreams[%d].address 无效: %q", i, upstream.Address))
+		}
+		if len(upstream.Username) > 255 || len(upstream.Password) > 255 {
+			errs = append(errs, fmt.Errorf("upstreams[%d] 的用户名和密码不能超过 255 字节", i))
+		}
+		if len(upstream.Hosts) == 0 && len(upstream.CIDRs) == 0 {
+			errs = append(errs, fmt.Errorf("upstreams[%d] 至少需要 hosts 或 cidrs 之一", i))
+		}
+		for _, cidr := range upstream.CIDRs {
+			if _, err := netip.ParsePrefix(cidr); err != nil {
+				errs = append(errs, fmt.Errorf("upstreams[%d].cidrs 中的网段无效: %q", i, cidr))
+			}
+		}
+	}
+	switch c.Egress.Strategy {
+	case "roundRobin", "sticky", "leastUsed":
+	default:
+		errs = append(errs, fmt.Errorf("egress.strategy 必须是 roundRobin、sticky 或 leastUsed: %q", c.Egress.Strategy))
+	}
+	for _, address := range c.Egress.Addresses {
+		if _, err := netip.ParseAddr(address); err == nil {
+			continue
+		}
+		if _, err := net.InterfaceByName(address); err != nil {
+			errs = append(errs, fmt.Errorf("egress.addresses 中的地址不是 IP 也不是本机网卡: %q", address))
+		}
+	}
+	if policy.MinPort < 1 || policy.MaxPort > 65535 || policy.MinPort > policy.MaxPort {
+		errs = append(errs, fmt.Errorf("destinationPolicy 端口范围无效: %d-%d", policy.MinPort, policy.MaxPort))
+	}
+	for host, addrs := range c.Hosts {
+		if len(addrs) == 0 {
+			errs = append(errs, fmt.Errorf("hosts[%q] 至少需要一个地址", host))
+		}
+		for _, addr := range addrs {
+			if _, err := netip.ParseAddr(addr); err != nil {
+				errs = append(errs, fmt.Errorf("hosts[%q] 中的地址无效: %q", host, addr))
+			}
+		}
+	}
+	if c.DNSCacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("dnsCacheTtl 不能为负数: %v", c.DNSCacheTTL))
+	}
+	if c.ProxyProtocol.Enabled && len(c.ProxyProtocol.TrustedCIDRs) == 0 {
+		errs = append(errs, errors.New("启用 proxyProtocol 时 trustedCidrs 不能为空"))
+	}
+	for _, cidr := range c.ProxyProtocol.TrustedCIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			errs = append(errs, fmt.Errorf("proxyProtocol.trustedCidrs 中的网段无效: %q", cidr))
+		}
+	}
+	if c.ProxyProtocol.HeaderTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("proxyProtocol.headerTimeout 必须大于 0: %v", c.ProxyProtocol.HeaderTimeout))
+	}
+	if c.Dial.Attempts < 1 || c.Dial.Attempts > 10 {
+		errs = append(errs, fmt.Errorf("dial.attempts 必须在 1-10 之间: %d", c.Dial.Attempts))
+	}
+	if c.Dial.InitialBackoff <= 0 {
+		errs = append(errs, fmt.Errorf("dial.initialBackoff 必须大于 0: %v", c.Dial.InitialBackoff))
+	}
+	if c.Dial.MaxBackoff < c.Dial.InitialBackoff {
+		errs = append(errs, fmt.Errorf("dial.maxBackoff 不能小于 initialBackoff: %v", c.Dial.MaxBackoff))
+	}
+	if c.Forward.MaxBufferedBytes < 64*1024 {
+		errs = append(errs, fmt.Errorf("forward.maxBufferedBytes 至少为 64KB: %d", c.Forward.MaxBufferedBytes))
+	}
+	if c.Forward.StallTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("forward.stallTimeout 必须大于 0: %v", c.Forward.StallTimeout))
+	}
+	if c.Forward.StallPolicy != "disconnect" && c.Forward.StallPolicy != "wait" {
+		errs = append(errs, fmt.Errorf("forward.stallPolicy 必须是 disconnect 或 wait: %q", c.Forward.StallPolicy))
+	}
+	if strings.ContainsFunc(c.Commands.Prefix, unicode.IsSpace) {
+		errs = append(errs, fmt.Errorf("commands.prefix 不能包含空白字符: %q", c.Commands.Prefix))
+	}
+	commandNames := make(map[string]bool)
+	for i, command := range c.Commands.Custom {
+		name := strings.ToLower(command.Name)
+		switch {
+		case name == "" || strings.ContainsFunc(name, unicode.IsSpace):
+			errs = append(errs, fmt.Errorf("commands.custom[%d].name 不能为空或包含空白字符: %q", i, command.Name))
+		case commandNames[name]:
+			errs = append(errs, fmt.Errorf("commands.custom[%d].name 重复: %q", i, command.Name))
+		}
+		commandNames[name] = true
+		if command.Reply == "" {
+			errs = append(errs, fmt.Errorf("commands.custom[%d].reply 不能为空", i))
+		}
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level 必须是 debug、info、warn 或 error: %q", c.Log.Level))
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format 必须是 text 或 json: %q", c.Log.Format))
+	}
+	if len(c.Capture.Players) > 0 && c.Capture.Dir == "" {
+		errs = append(errs, errors.New("capture.players 非空时 capture.dir 不能为空"))
+	}
+	if c.Admin.Enabled {
+		if _, _, err := net.SplitHostPort(c.Admin.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("admin.listen 无效: %q", c.Admin.Listen))
+		}
+		if len(c.Admin.Token) < 16 {
+			errs = append(errs, errors.New("启用 admin 时 admin.token 至少需要 16 个字符"))
+		}
+	}
+	if c.Metrics.Enabled {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.listen 无效: %q", c.Metrics.Listen))
+		}
+		if !strings.HasPrefix(c.Metrics.Path, "/") {
+			errs = append(errs, fmt.Errorf("metrics.path 必须以 / 开头: %q", c.Metrics.Path))
+		}
+	}
+	if c.Tunnel.Enabled {
+		if _, _, err := net.SplitHostPort(c.Tunnel.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("tunnel.listen 无效: %q", c.Tunnel.Listen))
+		}
+		if c.Tunnel.CertFile == "" || c.Tunnel.KeyFile == "" || c.Tunnel.ClientCAFile == "" {
+			errs = append(errs, errors.New("启用 tunnel 时 certFile、keyFile 和 clientCaFile 都不能为空"))
+		}
+	}
+	if c.WebSocket.Enabled {
+		if _, _, err := net.SplitHostPort(c.WebSocket.Listen); err != nil {
+			errs = append(errs, fmt.Errorf("webSocket.listen 无效: %q", c.WebSocket.Listen))
+		}
+		if !strings.HasPrefix(c.WebSocket.Path, "/") {
+			errs = append(errs, fmt.Errorf("webSocket.path 必须以 / 开头: %q", c.WebSocket.Path))
+		}
+		if (c.WebSocket.CertFile == "") != (c.WebSocket.KeyFile == "") {
+			errs = append(errs, errors.New("webSocket.certFile 和 keyFile 必须同时设置或同时留空"))
+		}
+	}
+	if len(c.Targets) == 0 && !c.AllowCustomTarget {
+		errs = append(errs, errors.New("targets 为空时 allowCustomTarget 必须为 true，否则玩家无法选择服务器"))
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	current    atomic.Pointer[Config]
+	configPath string
+	reloadMu   sync.Mutex
+	listeners  []func(old, new *Config)
+)
+
+// Get 返回当前生效的配置，调用方不得修改返回值
+func Get() *Config {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	c := DefaultConfig()
+	return &c
+}
+
+func ConfigPath() string {
+	return configPath
+}
+
+// ResolvePath 把配置中的相对路径解释为相对于配置文件所在目录
+func ResolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) || configPath == "" {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+// Load 在启动时读取配置文件（不存在则生成默认配置），叠加环境变量并校验
+func Load() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	path, err := resolveConfigPath()
+	if err != nil {
+		return err
+	}
+	configPath = path
+
+	config, err := fetchConfig(path, true)
+	if err != nil {
+		return err
+	}
+	current.Store(config)
+	printConfig(config)
+	return nil
+}
+
+// Reload 重新读取配置文件与环境变量，校验失败时保留旧配置
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	config, err := fetchConfig(configPath, false)
+	if err != nil {
+		return err
+	}
+
+	old := Get()
+	oldFields := fieldValues(old)
+	newFields := fieldValues(config)
+	for _, name := range restartOnlyFields {
+		if fmt.Sprint(oldFields[name]) != fmt.Sprint(newFields[name]) {
+			slog.Warn("配置项需要重启后生效，本次保留旧值", "field", name, "value", oldFields[name])
+		}
+	}
+	if old.Admin.Enabled != config.Admin.Enabled || old.Admin.Listen != config.Admin.Listen {
+		slog.Warn("配置项需要重启后生效，本次保留旧值", "field", "admin.enabled, admin.listen")
+	}
+	if old.Metrics != config.Metrics {
+		slog.Warn("配置项需要重启后生效，本次保留旧值", "field", "metrics")
+	}
+	if old.Tunnel.Enabled != config.Tunnel.Enabled || old.Tunnel.Listen != config.Tunnel.Listen {
+		slog.Warn("配置项需要重启后生效，本次保留旧值", "field", "tunnel.enabled, tunnel.listen")
+	}
+	if old.WebSocket.Enabled != config.WebSocket.Enabled || old.WebSocket.Listen != config.WebSocket.Listen {
+		slog.Warn("配置项需要重启后生效，本次保留旧值", "field", "webSocket.enabled, webSocket.listen")
+	}
+	config.ListenAddress = old.ListenAddress
+	config.Port = old.Port
+	config.MaxConnections = old.MaxConnections
+	config.Admin.Enabled = old.Admin.Enabled
+	config.Admin.Listen = old.Admin.Listen
+	config.Metrics = old.Metrics
+	config.Tunnel.Enabled = old.Tunnel.Enabled
+	config.Tunnel.Listen = old.Tunnel.Listen
+	config.WebSocket.Enabled = old.WebSocket.Enabled
+	config.WebSocket.Listen = old.WebSocket.Listen
+
+	current.Store(config)
+	slog.Info("配置已重新加载", "path", configPath)
+	for _, listener := range listeners {
+		listener(old, config)
+	}
+	return nil
+}
+
+// OnReload 注册热重载回调，回调在持有重载锁时同步执行
+func OnReload(listener func(old, new *Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	listeners = append(listeners, listener)
+}
+
+func resolveConfigPath() (string, error) {
+	if path := os.Getenv(envPrefix + "CONFIG"); path != "" {
+		return path, nil
+	}
 	exePath, err := os.Executable()
 	if err != nil {
-		return Config{}, fmt.Errorf("无法获得可执行路径: %v", err)
+		return "", fmt.Errorf("无法获得可执行路径: %v", err)
 	}
+	return filepath.Join(filepath.Dir(exePath), "config.json"), nil
+}
 
-	exeDir := filepath.Dir(exePath)
-	jsonFilePath := filepath.Join(exeDir, "config.json")
+func fetchConfig(jsonFilePath string, createIfMissing bool) (*Config, error) {
+	config := DefaultConfig()
 
 	if fileExists(jsonFilePath) {
 		fileData, err := os.ReadFile(jsonFilePath)
 		if err != nil {
-			return Config{}, fmt.Errorf("无法读取JSON文件: %v", err)
+			return nil, fmt.Errorf("无法读取JSON文件: %v", err)
 		}
 
 		if err := json.Unmarshal(fileData, &config); err != nil {
-			return Config{}, fmt.Errorf("未能解析JSON: %v", err)
+			return nil, fmt.Errorf("未能解析JSON %s: %v", jsonFilePath, err)
 		}
-
-		fmt.Println("加载现有配置:")
-	} else {
+	} else if createIfMissing {
 		fmt.Println("生成的新配置:")
 		jsonData, err := json.MarshalIndent(config, "", "  ")
 		if err != nil {
-			return Config{}, fmt.Errorf("未能序列化JSON: %v", err)
+			return nil, fmt.Errorf("未能序列化JSON: %v", err)
 		}
 		if err := os.WriteFile(jsonFilePath, jsonData, 0644); err != nil {
-			return Config{}, fmt.Errorf("无法写入JSON文件: %v", err)
+			return nil, fmt.Errorf("无法写入JSON文件: %v", err)
 		}
 	}
 
-	fmt.Printf("Port: %d\n", config.Port)
-	fmt.Printf("保存路径: %s\n", jsonFilePath)
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("配置无效 (%s):\n%v", jsonFilePath, err)
+	}
+	return &config, nil
+}
 
-	return config, nil
+// Redacted 返回隐藏了敏感字段的配置副本，用于打印或通过管理接口查看
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	if redacted.Admin.Token != "" {
+		redacted.Admin.Token = "******"
+	}
+	redacted.Upstreams = slices.Clone(redacted.Upstreams)
+	for i := range redacted.Upstreams {
+		if redacted.Upstreams[i].Password != "" {
+			redacted.Upstreams[i].Password = "******"
+		}
+	}
+	return &redacted
+}
+
+func printConfig(config *Config) {
+	jsonData, err := json.MarshalIndent(config.Redacted(), "", "  ")
+	if err == nil {
+		fmt.Printf("当前配置:\n%s\n", jsonData)
+	}
+	fmt.Printf("保存路径: %s\n", configPath)
 }
 
 func fileExists(path string) bool {
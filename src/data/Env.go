@@ -0,0 +1,131 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 环境变量名由 JSON 字段名转换而来，例如 maxConnections -> SHADOWPLAYER_MAX_CONNECTIONS，
+// 嵌套字段以下划线连接；列表、映射等复合类型的值按 JSON 解析
+const envPrefix = "SHADOWPLAYER_"
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func applyEnvOverrides(config *Config) error {
+	return applyEnvToStruct(reflect.ValueOf(config).Elem(), envPrefix)
+}
+
+func applyEnvToStruct(value reflect.Value, prefix string) error {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		envName := prefix + upperSnake(name)
+		fieldValue := value.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && !reflect.PointerTo(field.Type).Implements(jsonUnmarshalerType) {
+			if err := applyEnvToStruct(fieldValue, envName+"_"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(fieldValue, raw); err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %v", envName, err)
+		}
+	}
+	return nil
+}
+
+func setFromEnv(field reflect.Value, raw string) error {
+	if reflect.PointerTo(field.Type()).Implements(jsonUnmarshalerType) {
+		if _, err := strconv.ParseFloat(raw, 64); err != nil {
+			raw = strconv.Quote(raw)
+		}
+		return json.Unmarshal([]byte(raw), field.Addr().Interface())
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return json.Unmarshal([]byte(raw), field.Addr().Interface())
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name
+}
+
+func upperSnake(name string) string {
+	var sb strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) ||
+			(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToUpper(r))
+	}
+	return sb.String()
+}
+
+// fieldValues 以 JSON 字段名为键返回配置的顶层字段值，用于比较两份配置
+func fieldValues(config *Config) map[string]interface{} {
+	result := make(map[string]interface{})
+	value := reflect.ValueOf(config).Elem()
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if name := jsonName(t.Field(i)); name != "" {
+			result[name] = value.Field(i).Interface()
+		}
+	}
+	return result
+}
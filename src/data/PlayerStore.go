@@ -0,0 +1,286 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxRecentTargets = 5
+	MaxBookmarks     = 9
+
+	maxPlayerRecords     = 10000
+	playerRecordTTL      = 90 * 24 * time.Hour
+	playerStoreSaveDelay = 5 * time.Second
+)
+
+type PlayerTarget struct {
+	Host     string    `json:"host"`
+	Port     int32     `json:"port"`
+	Fog      bool      `json:"fog"`
+	LastUsed time.Time `json:"lastUsed"`
+}
+
+type PlayerBookmark struct {
+	Name string `json:"name"`
+	Host string `json:"host"`
+	Port int32  `json:"port"`
+	Fog  bool   `json:"fog"`
+}
+
+type PlayerRecord struct {
+	Recent    []PlayerTarget   `json:"recent"`
+	Bookmarks []PlayerBookmark `json:"bookmarks"`
+	Fog       bool             `json:"fog"`
+	UpdatedAt time.Time        `json:"updatedAt"`
+}
+
+func (r *PlayerRecord) Last() (PlayerTarget, bool) {
+	if r == nil || len(r.Recent) == 0 {
+		return PlayerTarget{}, false
+	}
+	return r.Recent[0], true
+}
+
+// PlayerStore 记录玩家最近使用的服务器、书签和去雾偏好，保存为 JSON 文件。
+// 修改在 playerStoreSaveDelay 后合并写入；超过 playerRecordTTL 没有更新的记录会被删除，
+// 记录数最多保留 maxPlayerRecords 条
+type PlayerStore struct {
+	mu        sync.Mutex
+	path      string
+	records   map[string]*PlayerRecord
+	saveTimer *time.Timer // 非 nil 表示有尚未写入文件的修改
+
+	saveMu sync.Mutex // 保证文件按修改的先后顺序写入
+}
+
+var (
+	playerStore     *PlayerStore
+	playerStoreOnce sync.Once
+)
+
+// Players 返回全局玩家存储，未配置 playerStore 时返回 nil
+func Players() *PlayerStore {
+	playerStoreOnce.Do(func() {
+		path := Get().PlayerStore
+		if path == "" {
+			return
+		}
+		path = ResolvePath(path)
+		store, err := openPlayerStore(path)
+		if err != nil {
+			slog.Warn("加载玩家存储失败，将使用空存储", "err", err)
+		}
+		playerStore = store
+	})
+	return playerStore
+}
+
+// PlayerKey 以玩家名和客户端IP作为存储键
+func PlayerKey(playerName string, clientIP string) string {
+	return playerName + "@" + clientIP
+}
+
+// PlayerPINKey 以玩家名和 PIN 作为存储键，PIN 只保存哈希值
+func PlayerPINKey(playerName string, pin string) string {
+	sum := sha256.Sum256([]byte(playerName + "\x00" + pin))
+	return fmt.Sprintf("pin:%x", sum[:16])
+}
+
+// playerPINOwnerKey 标记某个玩家名已有 PIN 记录，只保存玩家名的哈希值
+func playerPINOwnerKey(playerName string) string {
+	sum := sha256.Sum256([]byte("owner\x00" + playerName))
+	return fmt.Sprintf("pin-owner:%x", sum[:16])
+}
+
+func openPlayerStore(path string) (*PlayerStore, error) {
+	store := &PlayerStore{
+		path:    path,
+		records: make(map[string]*PlayerRecord),
+	}
+	if !fileExists(path) {
+		return store, nil
+	}
+	fileData, err := os.ReadFile(path)
+	if err != nil {
+		return store, fmt.Errorf("无法读取 %s: %v", path, err)
+	}
+	if err := json.Unmarshal(fileData, &store.records); err != nil {
+		return store, fmt.Errorf("未能解析 %s: %v", path, err)
+	}
+	store.pruneLocked(time.Now())
+	return store, nil
+}
+
+func (s *PlayerStore) Get(key string) (PlayerRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.records[key]
+	if !ok {
+		return PlayerRecord{}, false
+	}
+	result := *record
+	result.Recent = append([]PlayerTarget(nil), record.Recent...)
+	result.Bookmarks = append([]PlayerBookmark(nil), record.Bookmarks...)
+	return result, true
+}
+
+// RecordTarget 把成功连接的服务器放到最近列表首位，并记住去雾偏好
+func (s *PlayerStore) RecordTarget(key string, host string, port int32, fog bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.recordLocked(key)
+
+	recent := []PlayerTarget{{Host: host, Port: port, Fog: fog, LastUsed: time.Now()}}
+	for _, target := range record.Recent {
+		if target.Host == host && target.Port == port {
+			continue
+		}
+		if len(recent) >= maxRecentTargets {
+			break
+		}
+		recent = append(recent, target)
+	}
+	record.Recent = recent
+	record.Fog = fog
+	s.scheduleSaveLocked()
+	return nil
+}
+
+// AddBookmark 添加或覆盖同名书签
+func (s *PlayerStore) AddBookmark(key string, bookmark PlayerBookmark) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.recordLocked(key)
+
+	for i, existing := range record.Bookmarks {
+		if existing.Name == bookmark.Name {
+			record.Bookmarks[i] = bookmark
+			s.scheduleSaveLocked()
+			return nil
+		}
+	}
+	if len(record.Bookmarks) >= MaxBookmarks {
+		return fmt.Errorf("书签数量已达上限 %d", MaxBookmarks)
+	}
+	record.Bookmarks = append(record.Bookmarks, bookmark)
+	s.scheduleSaveLocked()
+	return nil
+}
+
+// MarkPIN 记录该玩家名已有 PIN 记录，之后没有对应记录的 PIN 才视为猜错
+func (s *PlayerStore) MarkPIN(playerName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordLocked(playerPINOwnerKey(playerName))
+	s.scheduleSaveLocked()
+}
+
+// HasPIN 判断该玩家名是否已有 PIN 记录
+func (s *PlayerStore) HasPIN(playerName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.records[playerPINOwnerKey(playerName)]
+	return ok
+}
+
+func (s *PlayerStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[key]; !ok {
+		return nil
+	}
+	delete(s.records, key)
+	s.scheduleSaveLocked()
+	return nil
+}
+
+func (s *PlayerStore) recordLocked(key string) *PlayerRecord {
+	record, ok := s.records[key]
+	if !ok {
+		record = &PlayerRecord{}
+		s.records[key] = record
+	}
+	record.UpdatedAt = time.Now()
+	return record
+}
+
+// pruneLocked 删除超过 playerRecordTTL 没有更新的记录，仍超过上限时从最久未更新的开始删除
+func (s *PlayerStore) pruneLocked(now time.Time) {
+	for key, record := range s.records {
+		if now.Sub(record.UpdatedAt) > playerRecordTTL {
+			delete(s.records, key)
+		}
+	}
+	if len(s.records) <= maxPlayerRecords {
+		return
+	}
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.records[keys[i]].UpdatedAt.Before(s.records[keys[j]].UpdatedAt)
+	})
+	for _, key := range keys[:len(keys)-maxPlayerRecords] {
+		delete(s.records, key)
+	}
+}
+
+// scheduleSaveLocked 安排一次延迟写入，期间的多次修改只写一次文件
+func (s *PlayerStore) scheduleSaveLocked() {
+	if s.saveTimer != nil {
+		return
+	}
+	s.saveTimer = time.AfterFunc(playerStoreSaveDelay, func() {
+		if err := s.Flush(); err != nil {
+			slog.Error("保存玩家存储失败", "err", err)
+		}
+	})
+}
+
+// Flush 立即写入尚未保存的修改，写入失败时稍后重试
+func (s *PlayerStore) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.saveTimer == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.saveTimer.Stop()
+	s.saveTimer = nil
+	s.pruneLocked(time.Now())
+	jsonData, err := json.MarshalIndent(s.records, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("未能序列化玩家存储: %v", err)
+	}
+
+	if err := s.writeFile(jsonData); err != nil {
+		s.mu.Lock()
+		s.scheduleSaveLocked()
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// writeFile 先写临时文件再重命名，避免进程中断时留下半个文件
+func (s *PlayerStore) writeFile(jsonData []byte) error {
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, jsonData, 0600); err != nil {
+		return fmt.Errorf("无法写入 %s: %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("无法替换 %s: %v", s.path, err)
+	}
+	return nil
+}
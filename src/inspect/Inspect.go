@@ -0,0 +1,362 @@
+package inspect
+
+import (
+	"ShadowPlayer/src/capture"
+	spio "ShadowPlayer/src/io"
+	spnet "ShadowPlayer/src/net"
+	_type "ShadowPlayer/src/type"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+const usage = `用法: shadowplayer inspect [选项] <抓包文件>
+
+按时间顺序解码抓包文件中的每个帧。
+
+选项:
+`
+
+// filter 是解析后的过滤条件，时间窗口以第一个帧为起点
+type filter struct {
+	types    map[_type.PacketType]bool
+	dirs     map[capture.Direction]bool
+	from, to time.Time
+}
+
+// Run 执行 inspect 子命令，返回进程退出码
+func Run(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	format := flags.String("format", "text", "输出格式: text 或 json（每行一个 JSON 对象）")
+	typeList := flags.String("type", "", "只显示这些包类型，逗号分隔，可写类型号或名称，例如 106,TEAM_LIST")
+	dirList := flags.String("dir", "", "只显示这些方向，逗号分隔: client->sp、sp->client、target->sp、sp->target")
+	from := flags.String("from", "", "时间窗口起点: 相对第一个帧的时长（如 90s）或 RFC3339 时间")
+	to := flags.String("to", "", "时间窗口终点，格式同 -from")
+	showHex := flags.Bool("hex", false, "为所有帧输出原始内容，默认只为解码失败或没有解码器的帧输出")
+	maxHex := flags.Int("max-hex", 512, "文本格式下每个帧最多输出的十六进制字节数，0 表示不限制")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var f filter
+	var err error
+	if f.types, err = parseTypes(*typeList); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if f.dirs, err = parseDirections(*dirList); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "未知的输出格式: %s\n", *format)
+		return 2
+	}
+
+	frames, err := capture.ReadFile(flags.Arg(0))
+	if err != nil && len(frames) == 0 {
+		fmt.Fprintf(os.Stderr, "读取抓包文件失败: %v\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "抓包文件不完整，只显示已读取的 %d 个帧: %v\n", len(frames), err)
+	}
+	if len(frames) == 0 {
+		return 0
+	}
+
+	start := frames[0].Time
+	if f.from, err = parseTimeBound(*from, start); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if f.to, err = parseTimeBound(*to, start); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var out output
+	if *format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		out = &jsonOutput{encoder: encoder}
+	} else {
+		out = &textOutput{w: os.Stdout, maxHex: *maxHex}
+	}
+	for _, frame := range frames {
+		if !f.match(frame) {
+			continue
+		}
+		out.write(decodeFrame(frame, start, *showHex))
+	}
+	return 0
+}
+
+func (f *filter) match(frame capture.Frame) bool {
+	if len(f.types) > 0 && !f.types[frame.Packet.Type] {
+		return false
+	}
+	if len(f.dirs) > 0 && !f.dirs[frame.Direction] {
+		return false
+	}
+	if !f.from.IsZero() && frame.Time.Before(f.from) {
+		return false
+	}
+	if !f.to.IsZero() && frame.Time.After(f.to) {
+		return false
+	}
+	return true
+}
+
+func parseTypes(list string) (map[_type.PacketType]bool, error) {
+	result := make(map[_type.PacketType]bool)
+	for _, item := range splitList(list) {
+		if n, err := strconv.Atoi(item); err == nil {
+			result[_type.PacketType(n)] = true
+			continue
+		}
+		info, ok := _type.LookupByName(strings.ToUpper(item))
+		if !ok {
+			return nil, fmt.Errorf("未知的包类型: %s", item)
+		}
+		result[info.Type] = true
+	}
+	return result, nil
+}
+
+func parseDirections(list string) (map[capture.Direction]bool, error) {
+	all := []capture.Direction{capture.ClientToProxy, capture.ProxyToClient, capture.TargetToProxy, capture.ProxyToTarget}
+	result := make(map[capture.Direction]bool)
+	for _, item := range splitList(list) {
+		found := false
+		for _, dir := range all {
+			if dir.String() == item {
+				result[dir] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("未知的方向: %s", item)
+		}
+	}
+	return result, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseTimeBound 解析相对时长或绝对时间，空字符串表示不限制
+func parseTimeBound(s string, start time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return start.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s（应为 90s 这样的时长或 RFC3339 时间）", s)
+}
+
+// decodedFrame 是一个帧的解码结果
+type decodedFrame struct {
+	Time        time.Time   `json:"time"`
+	Elapsed     float64     `json:"elapsed"` // 距第一个帧的秒数
+	Direction   string      `json:"direction"`
+	Type        int32       `json:"type"`
+	TypeName    string      `json:"typeName"`
+	Size        int         `json:"size"`
+	Decoded     interface{} `json:"decoded,omitempty"`
+	Error       string      `json:"error,omitempty"`
+	ErrorOffset *int64      `json:"errorOffset,omitempty"` // 解析停止的字节偏移，未知时为空
+	NoDecoder   bool        `json:"noDecoder,omitempty"`
+	Bytes       []byte      `json:"bytes,omitempty"` // 解码失败、没有解码器或指定 -hex 时附带原始内容
+}
+
+// teamList 在 115 包的基础上附带解压后的队伍数据
+type teamList struct {
+	spnet.Packet_115
+	TeamData []byte
+}
+
+func decodeFrame(frame capture.Frame, start time.Time, withBytes bool) decodedFrame {
+	packet := frame.Packet
+	result := decodedFrame{
+		Time:      frame.Time,
+		Elapsed:   frame.Time.Sub(start).Seconds(),
+		Direction: frame.Direction.String(),
+		Type:      int32(packet.Type),
+		TypeName:  packet.Type.Name(),
+		Size:      len(packet.Bytes),
+	}
+	if withBytes {
+		result.Bytes = packet.Bytes
+	}
+
+	info, ok := _type.Lookup(packet.Type)
+	if !ok || info.Decode == nil {
+		result.NoDecoder = true
+		result.Bytes = packet.Bytes
+		return result
+	}
+
+	decoded, err := info.Decode(packet)
+	if err == nil && packet.Type == _type.PacketTeamList {
+		decoded, err = decodeTeamList(decoded.(spnet.Packet_115))
+	}
+	if err != nil {
+		result.Error = err.Error()
+		var serializeErr *spio.SerializeError
+		if errors.As(err, &serializeErr) && serializeErr.Offset >= 0 {
+			result.ErrorOffset = &serializeErr.Offset
+		}
+		result.Bytes = packet.Bytes
+		return result
+	}
+	result.Decoded = decoded
+	return result
+}
+
+// decodeTeamList 解压 115 包中的 gzip 队伍数据
+func decodeTeamList(data spnet.Packet_115) (interface{}, error) {
+	stream, err := spio.GetGzipInputStream(false, data.TeamBlock)
+	if err != nil {
+		return nil, fmt.Errorf("队伍数据不是有效的 gzip: %w", err)
+	}
+	teamData, err := stream.ReadAllBytes()
+	if err != nil {
+		return nil, fmt.Errorf("解压队伍数据失败: %w", err)
+	}
+	return teamList{Packet_115: data, TeamData: teamData}, nil
+}
+
+type output interface {
+	write(frame decodedFrame)
+}
+
+type jsonOutput struct {
+	encoder *json.Encoder
+}
+
+func (o *jsonOutput) write(frame decodedFrame) {
+	o.encoder.Encode(frame)
+}
+
+type textOutput struct {
+	w      io.Writer
+	maxHex int
+}
+
+var spewConfig = spew.ConfigState{
+	Indent:                  "    ",
+	DisablePointerAddresses: true,
+	DisableCapacities:       true,
+	SortKeys:                true,
+}
+
+func (o *textOutput) write(frame decodedFrame) {
+	fmt.Fprintf(o.w, "%s +%.3fs %-10s %s(%d) %d 字节\n",
+		frame.Time.Format("15:04:05.000000"), frame.Elapsed, frame.Direction, frame.TypeName, frame.Type, frame.Size)
+	switch {
+	case frame.Error != "":
+		fmt.Fprintf(o.w, "  解码失败: %s\n", frame.Error)
+		mark := int64(-1)
+		if frame.ErrorOffset != nil {
+			mark = *frame.ErrorOffset
+			fmt.Fprintf(o.w, "  解析停止于第 %d 字节（标记 >>）\n", mark)
+		}
+		hexDump(o.w, frame.Bytes, mark, o.maxHex)
+	case frame.NoDecoder:
+		fmt.Fprintln(o.w, "  没有解码器")
+		hexDump(o.w, frame.Bytes, -1, o.maxHex)
+	default:
+		fmt.Fprint(o.w, indent(spewConfig.Sdump(frame.Decoded)))
+		if frame.Bytes != nil {
+			hexDump(o.w, frame.Bytes, -1, o.maxHex)
+		}
+	}
+	fmt.Fprintln(o.w)
+}
+
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	var sb strings.Builder
+	for _, line := range lines {
+		if line != "" {
+			sb.WriteString("  ")
+			sb.WriteString(line)
+		}
+	}
+	return sb.String()
+}
+
+// hexDump 以绝对偏移输出十六进制内容，mark 所在行以 >> 标记。内容过长时截取 mark 附近的部分
+func hexDump(w io.Writer, data []byte, mark int64, limit int) {
+	begin, end := 0, len(data)
+	if limit > 0 && len(data) > limit {
+		if mark > 0 {
+			begin = max(0, int(mark)-limit/2) &^ 15
+		}
+		end = min(len(data), begin+limit)
+	}
+	if begin > 0 {
+		fmt.Fprintf(w, "  ... 省略前 %d 字节\n", begin)
+	}
+	for offset := begin; offset < end; offset += 16 {
+		line := data[offset:min(offset+16, end)]
+		prefix := "  "
+		if mark >= int64(offset) && mark < int64(offset+16) {
+			prefix = ">>"
+		}
+		var hexPart, textPart strings.Builder
+		for i := 0; i < 16; i++ {
+			if i < len(line) {
+				fmt.Fprintf(&hexPart, "%02x ", line[i])
+				if line[i] >= 0x20 && line[i] < 0x7f {
+					textPart.WriteByte(line[i])
+				} else {
+					textPart.WriteByte('.')
+				}
+			} else {
+				hexPart.WriteString("   ")
+			}
+			if i == 7 {
+				hexPart.WriteByte(' ')
+			}
+		}
+		fmt.Fprintf(w, "%s %08x  %s |%s|\n", prefix, offset, hexPart.String(), textPart.String())
+	}
+	if mark >= int64(len(data)) {
+		fmt.Fprintf(w, ">> %08x  (数据在此结束)\n", len(data))
+	}
+	if end < len(data) {
+		fmt.Fprintf(w, "  ... 省略后 %d 字节\n", len(data)-end)
+	}
+}
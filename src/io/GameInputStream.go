@@ -14,23 +14,46 @@ var (
 	ErrEOF               = errors.New("EOF")
 	ErrUTFDataFormat     = errors.New("malformed UTF-8 input")
 	ErrNegativeUTFLength = errors.New("UTF length is negative")
+	ErrInvalidLength     = errors.New("invalid length prefix")
 )
 
 type GameInputStream struct {
 	buffer       io.Reader
+	source       io.Reader
 	parseVersion int
 }
 
+// countingReader 记录已经读取的字节数，用于定位解析失败的位置
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 func NewGameInputStreamFromBytes(data []byte, parseVersion int) *GameInputStream {
 	return NewGameInputStream(bytes.NewReader(data), parseVersion)
 }
 func NewGameInputStream(reader io.Reader, parseVersion int) *GameInputStream {
 	return &GameInputStream{
-		buffer:       reader,
+		buffer:       &countingReader{reader: reader},
+		source:       reader,
 		parseVersion: parseVersion,
 	}
 }
 
+// Offset 返回从流开头已经读取的字节数
+func (gis *GameInputStream) Offset() int64 {
+	if counter, ok := gis.buffer.(*countingReader); ok {
+		return counter.n
+	}
+	return -1
+}
+
 func (gis *GameInputStream) ReadByte() (byte, error) {
 	buf := make([]byte, 1)
 	_, err := io.ReadFull(gis.buffer, buf)
@@ -134,7 +157,31 @@ func (gis *GameInputStream) Skip(n int) error {
 	return err
 }
 
+// Remaining 返回流中尚未读取的字节数，底层不是内存中的数据时返回 -1
+func (gis *GameInputStream) Remaining() int {
+	if sized, ok := gis.source.(interface{ Len() int }); ok {
+		return sized.Len()
+	}
+	return -1
+}
+
+// ReadNBytes 读取 n 个字节。长度通常来自对端发来的前缀，
+// 负数或超过剩余数据的长度直接报错，不会按该长度分配内存
 func (gis *GameInputStream) ReadNBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidLength, n)
+	}
+	remaining := gis.Remaining()
+	if remaining < 0 {
+		buf, err := io.ReadAll(io.LimitReader(gis.buffer, int64(n)))
+		if err == nil && len(buf) < n {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	if n > remaining {
+		return nil, fmt.Errorf("%w: %d bytes requested, %d remaining", ErrInvalidLength, n, remaining)
+	}
 	buf := make([]byte, n)
 	_, err := io.ReadFull(gis.buffer, buf)
 	return buf, err
@@ -218,7 +265,7 @@ func (gis *GameInputStream) GetDecodeBytes() ([]byte, error) {
 }
 
 func (gis *GameInputStream) Size() int64 {
-	if seeker, ok := gis.buffer.(io.Seeker); ok {
+	if seeker, ok := gis.source.(io.Seeker); ok {
 		pos, _ := seeker.Seek(0, io.SeekCurrent)
 		end, _ := seeker.Seek(0, io.SeekEnd)
 		_, _ = seeker.Seek(pos, io.SeekStart)
@@ -228,7 +275,7 @@ func (gis *GameInputStream) Size() int64 {
 }
 
 func (gis *GameInputStream) Close() error {
-	if closer, ok := gis.buffer.(io.Closer); ok {
+	if closer, ok := gis.source.(io.Closer); ok {
 		return closer.Close()
 	}
 	return nil
@@ -241,7 +288,8 @@ func (gis *GameInputStream) readUTF(isLong bool) (string, error) {
 	if isLong {
 		utfLen, err = gis.ReadInt()
 	} else {
-		val, _ := gis.ReadUnsignedShort()
+		var val uint16
+		val, err = gis.ReadUnsignedShort()
 		utfLen = int32(val)
 	}
 
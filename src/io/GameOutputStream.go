@@ -26,7 +26,7 @@ func NewGameOutputStreamFromBytes() *GameOutputStream {
 	return NewGameOutputStream(buf)
 }
 
-func (gos *GameOutputStream) CreatePacket(packetType int32) (_type.Packet, error) {
+func (gos *GameOutputStream) CreatePacket(packetType _type.PacketType) (_type.Packet, error) {
 	if buf, ok := gos.buffer.(*bytes.Buffer); ok {
 		return _type.Packet{
 			Type:  packetType,
@@ -0,0 +1,472 @@
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 结构体标签格式: `rw:"<kind>[,since=N][,version]"`
+//
+//	string / longstring / isstring  字符串，isstring 对应 *string 时 nil 表示不存在
+//	int / isint / short / long / float / double / bool / byte
+//	bytes    int 长度前缀的字节块
+//	skip=N   固定 N 字节，原样保存在 []byte 字段中以便精确回写
+//	rest     剩余的全部字节
+//	struct   嵌套结构体（未写标签的结构体字段同样按嵌套处理）
+//
+// since=N 仅在当前版本 >= N 时读写该字段；version 表示以该字段的值作为后续字段的版本。
+// 解码时初始版本为 GameInputStream 的 parseVersion，编码时为 0。
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindLongString
+	kindIsString
+	kindInt
+	kindIsInt
+	kindShort
+	kindLong
+	kindFloat
+	kindDouble
+	kindBool
+	kindByte
+	kindBytes
+	kindSkip
+	kindRest
+	kindStruct
+)
+
+var kindNames = map[string]fieldKind{
+	"string":     kindString,
+	"longstring": kindLongString,
+	"isstring":   kindIsString,
+	"int":        kindInt,
+	"isint":      kindIsInt,
+	"short":      kindShort,
+	"long":       kindLong,
+	"float":      kindFloat,
+	"double":     kindDouble,
+	"bool":       kindBool,
+	"byte":       kindByte,
+	"bytes":      kindBytes,
+	"skip":       kindSkip,
+	"rest":       kindRest,
+	"struct":     kindStruct,
+}
+
+type fieldPlan struct {
+	index     int
+	name      string
+	kind      fieldKind
+	size      int
+	since     int
+	isVersion bool
+	nested    []fieldPlan
+}
+
+type SerializeError struct {
+	Field  string
+	Offset int64 // 解码时出错字段的起始偏移，编码时为 -1
+	Err    error
+}
+
+func (e *SerializeError) Error() string {
+	if e.Offset >= 0 {
+		return fmt.Sprintf("%s (偏移 %d): %v", e.Field, e.Offset, e.Err)
+	}
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *SerializeError) Unwrap() error {
+	return e.Err
+}
+
+var planCache sync.Map
+
+func Marshal(v interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := MarshalTo(NewGameOutputStream(buf), v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func MarshalTo(gos *GameOutputStream, v interface{}) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		return fmt.Errorf("Marshal 需要结构体, 得到 %T", v)
+	}
+	plan, err := planFor(value.Type())
+	if err != nil {
+		return err
+	}
+	version := 0
+	return encodeFields(gos, value, plan, &version)
+}
+
+func Unmarshal(gis *GameInputStream, v interface{}) error {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Unmarshal 需要结构体指针, 得到 %T", v)
+	}
+	value := ptr.Elem()
+	plan, err := planFor(value.Type())
+	if err != nil {
+		return err
+	}
+	version := gis.parseVersion
+	return decodeFields(gis, value, plan, &version)
+}
+
+func planFor(t reflect.Type) ([]fieldPlan, error) {
+	if cached, ok := planCache.Load(t); ok {
+		return cached.([]fieldPlan), nil
+	}
+	plan, err := buildPlan(t)
+	if err != nil {
+		return nil, err
+	}
+	planCache.Store(t, plan)
+	return plan, nil
+}
+
+func buildPlan(t reflect.Type) ([]fieldPlan, error) {
+	var plan []fieldPlan
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("rw")
+		if tag == "-" {
+			continue
+		}
+		if !hasTag {
+			if field.Type.Kind() != reflect.Struct {
+				continue
+			}
+			tag = "struct"
+		}
+		if !field.IsExported() {
+			return nil, fmt.Errorf("%s.%s: 带 rw 标签的字段必须导出", t.Name(), field.Name)
+		}
+
+		fp := fieldPlan{index: i, name: t.Name() + "." + field.Name}
+		for n, part := range strings.Split(tag, ",") {
+			key, arg, hasArg := strings.Cut(strings.TrimSpace(part), "=")
+			if n == 0 {
+				kind, ok := kindNames[key]
+				if !ok {
+					return nil, fmt.Errorf("%s: 未知的类型 %q", fp.name, key)
+				}
+				fp.kind = kind
+				if kind == kindSkip {
+					size, err := strconv.Atoi(arg)
+					if !hasArg || err != nil || size < 0 {
+						return nil, fmt.Errorf("%s: skip 需要非负长度", fp.name)
+					}
+					fp.size = size
+				}
+				continue
+			}
+			switch key {
+			case "since":
+				since, err := strconv.Atoi(arg)
+				if err != nil {
+					return nil, fmt.Errorf("%s: 无效的 since %q", fp.name, arg)
+				}
+				fp.since = since
+			case "version":
+				fp.isVersion = true
+			default:
+				return nil, fmt.Errorf("%s: 未知的选项 %q", fp.name, key)
+			}
+		}
+
+		if err := checkFieldType(fp, field.Type); err != nil {
+			return nil, err
+		}
+		if fp.kind == kindStruct {
+			nested, err := planFor(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			fp.nested = nested
+		}
+		plan = append(plan, fp)
+	}
+	return plan, nil
+}
+
+func checkFieldType(fp fieldPlan, t reflect.Type) error {
+	ok := false
+	switch fp.kind {
+	case kindString, kindLongString:
+		ok = t.Kind() == reflect.String
+	case kindIsString:
+		ok = t.Kind() == reflect.String || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.String)
+	case kindInt:
+		ok = t.Kind() == reflect.Int32
+	case kindIsInt:
+		ok = t.Kind() == reflect.Int32 || (t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Int32)
+	case kindShort:
+		ok = t.Kind() == reflect.Int16 || t.Kind() == reflect.Uint16
+	case kindLong:
+		ok = t.Kind() == reflect.Int64
+	case kindFloat:
+		ok = t.Kind() == reflect.Float32
+	case kindDouble:
+		ok = t.Kind() == reflect.Float64
+	case kindBool:
+		ok = t.Kind() == reflect.Bool
+	case kindByte:
+		ok = t.Kind() == reflect.Uint8
+	case kindBytes, kindSkip, kindRest:
+		ok = t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8
+	case kindStruct:
+		ok = t.Kind() == reflect.Struct
+	}
+	if !ok {
+		return fmt.Errorf("%s: 字段类型 %s 与标签不匹配", fp.name, t)
+	}
+	if fp.isVersion && !isIntegerKind(t.Kind()) {
+		return fmt.Errorf("%s: version 字段必须是整数", fp.name)
+	}
+	return nil
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint8, reflect.Uint16:
+		return true
+	}
+	return false
+}
+
+func integerValue(v reflect.Value) int {
+	switch v.Kind() {
+	case reflect.Uint8, reflect.Uint16:
+		return int(v.Uint())
+	default:
+		return int(v.Int())
+	}
+}
+
+func decodeFields(gis *GameInputStream, value reflect.Value, plan []fieldPlan, version *int) error {
+	for _, fp := range plan {
+		if *version < fp.since {
+			continue
+		}
+		field := value.Field(fp.index)
+		start := gis.Offset()
+		if err := decodeField(gis, field, fp, version); err != nil {
+			if _, ok := err.(*SerializeError); ok {
+				return err
+			}
+			return &SerializeError{Field: fp.name, Offset: start, Err: err}
+		}
+		if fp.isVersion {
+			*version = integerValue(field)
+		}
+	}
+	return nil
+}
+
+func decodeField(gis *GameInputStream, field reflect.Value, fp fieldPlan, version *int) error {
+	switch fp.kind {
+	case kindString, kindLongString:
+		s, err := gis.readUTF(fp.kind == kindLongString)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	case kindIsString:
+		exists, err := gis.ReadBoolean()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		s, err := gis.ReadString()
+		if err != nil {
+			return err
+		}
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.ValueOf(&s))
+		} else {
+			field.SetString(s)
+		}
+	case kindInt:
+		n, err := gis.ReadInt()
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case kindIsInt:
+		exists, err := gis.ReadBoolean()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		n, err := gis.ReadInt()
+		if err != nil {
+			return err
+		}
+		if field.Kind() == reflect.Ptr {
+			field.Set(reflect.ValueOf(&n))
+		} else {
+			field.SetInt(int64(n))
+		}
+	case kindShort:
+		n, err := gis.ReadUnsignedShort()
+		if err != nil {
+			return err
+		}
+		if field.Kind() == reflect.Uint16 {
+			field.SetUint(uint64(n))
+		} else {
+			field.SetInt(int64(int16(n)))
+		}
+	case kindLong:
+		n, err := gis.ReadLong()
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case kindFloat:
+		f, err := gis.ReadFloat()
+		if err != nil {
+			return err
+		}
+		field.SetFloat(float64(f))
+	case kindDouble:
+		f, err := gis.ReadDouble()
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case kindBool:
+		b, err := gis.ReadBoolean()
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case kindByte:
+		b, err := gis.ReadByte()
+		if err != nil {
+			return err
+		}
+		field.SetUint(uint64(b))
+	case kindBytes:
+		b, err := gis.ReadStreamBytes()
+		if err != nil {
+			return err
+		}
+		field.SetBytes(b)
+	case kindSkip:
+		b, err := gis.ReadNBytes(fp.size)
+		if err != nil {
+			return err
+		}
+		field.SetBytes(b)
+	case kindRest:
+		b, err := gis.ReadAllBytes()
+		if err != nil {
+			return err
+		}
+		field.SetBytes(b)
+	case kindStruct:
+		nestedVersion := *version
+		return decodeFields(gis, field, fp.nested, &nestedVersion)
+	}
+	return nil
+}
+
+func encodeFields(gos *GameOutputStream, value reflect.Value, plan []fieldPlan, version *int) error {
+	for _, fp := range plan {
+		if *version < fp.since {
+			continue
+		}
+		field := value.Field(fp.index)
+		if err := encodeField(gos, field, fp, version); err != nil {
+			if _, ok := err.(*SerializeError); ok {
+				return err
+			}
+			return &SerializeError{Field: fp.name, Offset: -1, Err: err}
+		}
+		if fp.isVersion {
+			*version = integerValue(field)
+		}
+	}
+	return nil
+}
+
+func encodeField(gos *GameOutputStream, field reflect.Value, fp fieldPlan, version *int) error {
+	switch fp.kind {
+	case kindString:
+		return gos.WriteString(field.String())
+	case kindLongString:
+		return gos.WriteLongString(field.String())
+	case kindIsString:
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return gos.WriteIsString(nil)
+			}
+			s := field.Elem().String()
+			return gos.WriteIsString(&s)
+		}
+		s := field.String()
+		return gos.WriteIsString(&s)
+	case kindInt:
+		return gos.WriteInt(int32(field.Int()))
+	case kindIsInt:
+		if field.Kind() == reflect.Ptr {
+			if field.IsNil() {
+				return gos.WriteIsInt(nil)
+			}
+			n := int32(field.Elem().Int())
+			return gos.WriteIsInt(&n)
+		}
+		n := int32(field.Int())
+		return gos.WriteIsInt(&n)
+	case kindShort:
+		if field.Kind() == reflect.Uint16 {
+			return gos.WriteShort(int16(field.Uint()))
+		}
+		return gos.WriteShort(int16(field.Int()))
+	case kindLong:
+		return gos.WriteLong(field.Int())
+	case kindFloat:
+		return gos.WriteFloat(float32(field.Float()))
+	case kindDouble:
+		return gos.WriteDouble(field.Float())
+	case kindBool:
+		return gos.WriteBoolean(field.Bool())
+	case kindByte:
+		return gos.WriteByte(byte(field.Uint()))
+	case kindBytes:
+		return gos.WriteBytesAndLength(field.Bytes())
+	case kindSkip:
+		b := field.Bytes()
+		if len(b) > fp.size {
+			return fmt.Errorf("长度 %d 超过 skip=%d", len(b), fp.size)
+		}
+		if err := gos.WriteBytes(b); err != nil {
+			return err
+		}
+		return gos.WriteBytes(make([]byte, fp.size-len(b)))
+	case kindRest:
+		return gos.WriteBytes(field.Bytes())
+	case kindStruct:
+		nestedVersion := *version
+		return encodeFields(gos, field, fp.nested, &nestedVersion)
+	}
+	return nil
+}
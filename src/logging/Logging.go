@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"ShadowPlayer/src/data"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var level slog.LevelVar
+
+// Setup 按配置设置全局日志，标准库 log 的输出也会经过这里。
+// 级别和格式在热重载后立即生效
+func Setup() {
+	apply(data.Get().Log)
+	data.OnReload(func(old, new *data.Config) {
+		if old.Log != new.Log {
+			apply(new.Log)
+		}
+	})
+}
+
+func apply(config data.LogConfig) {
+	level.Set(parseLevel(config.Level))
+	slog.SetDefault(slog.New(newHandler(os.Stderr, config.Format, &level)))
+}
+
+func parseLevel(s string) slog.Level {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToLower(s))); err != nil {
+		return slog.LevelInfo
+	}
+	return l
+}
+
+func newHandler(w io.Writer, format string, leveler slog.Leveler) slog.Handler {
+	options := &slog.HandlerOptions{Level: leveler}
+	if format == "json" {
+		return slog.NewJSONHandler(w, options)
+	}
+	return slog.NewTextHandler(w, options)
+}
+
+// OpenSession 返回带有会话 ID 的日志器。配置了 log.sessionDir 时同时写入该会话专属的文件，
+// 返回的 io.Closer 在会话结束时关闭文件；未配置时为空操作
+func OpenSession(sessionID string) (*slog.Logger, io.Closer) {
+	base := slog.Default()
+	config := data.Get().Log
+	if config.SessionDir == "" {
+		return base.With("session", sessionID), nopCloser{}
+	}
+
+	file, err := createSessionFile(data.ResolvePath(config.SessionDir), sessionID)
+	if err != nil {
+		base.Warn("无法创建会话日志文件", "session", sessionID, "err", err)
+		return base.With("session", sessionID), nopCloser{}
+	}
+
+	handler := teeHandler{base.Handler(), newHandler(file, config.Format, slog.LevelDebug)}
+	return slog.New(handler).With("session", sessionID), file
+}
+
+func createSessionFile(dir string, sessionID string) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-%s.log", time.Now().Format("20060102-150405"), sessionID)
+	return os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// teeHandler 把每条记录交给所有启用了该级别的 handler
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, l) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, record.Level) {
+			if err := h.Handle(ctx, record.Clone()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	result := make(teeHandler, len(t))
+	for i, h := range t {
+		result[i] = h.WithAttrs(attrs)
+	}
+	return result
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	result := make(teeHandler, len(t))
+	for i, h := range t {
+		result[i] = h.WithGroup(name)
+	}
+	return result
+}
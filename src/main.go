@@ -1,16 +1,84 @@
 package main
 
 import (
+	"ShadowPlayer/src/admin"
+	"ShadowPlayer/src/data"
+	"ShadowPlayer/src/inspect"
+	"ShadowPlayer/src/logging"
+	"ShadowPlayer/src/metrics"
 	"ShadowPlayer/src/net"
+	"ShadowPlayer/src/tunnel"
+	"ShadowPlayer/src/websocket"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
-	go net.Start()
+	if len(os.Args) > 1 && os.Args[1] == "inspect" {
+		os.Exit(inspect.Run(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		os.Exit(tunnel.RunClient(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bridge" {
+		os.Exit(websocket.RunBridge(os.Args[2:]))
+	}
+
+	if err := data.Load(); err != nil {
+		fmt.Println(err)
+		fmt.Println("按回车键退出...")
+		bufio.NewReader(os.Stdin).ReadBytes('\n')
+		os.Exit(1)
+	}
+	logging.Setup()
+
+	server := net.NewServer()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, net.ErrServerClosed) {
+			slog.Error("端口已被占用", "port", data.Get().Port, "err", err)
+			fmt.Println("\n端口被占用，请检查配置或关闭占用该端口的程序")
+			fmt.Println("按回车键退出...")
+			bufio.NewReader(os.Stdin).ReadBytes('\n')
+			os.Exit(1)
+		}
+	}()
+
+	if data.Get().Tunnel.Enabled {
+		go func() {
+			if err := server.ListenAndServeTunnel(); err != nil && !errors.Is(err, net.ErrServerClosed) {
+				slog.Error("隧道入口启动失败", "err", err)
+			}
+		}()
+	}
+
+	if data.Get().WebSocket.Enabled {
+		go func() {
+			if err := server.ListenAndServeWebSocket(); err != nil && !errors.Is(err, net.ErrServerClosed) {
+				slog.Error("WebSocket 入口启动失败", "err", err)
+			}
+		}()
+	}
+
+	adminServer := admin.New(server)
+	if err := adminServer.Start(); err != nil {
+		slog.Error("管理接口启动失败", "err", err)
+	}
+
+	if config := data.Get().Metrics; config.Enabled {
+		metricsServer, err := metrics.Serve(config.Listen, config.Path)
+		if err != nil {
+			slog.Error("指标接口启动失败", "err", err)
+		} else {
+			defer metricsServer.Close()
+		}
+	}
 
 	fmt.Println("服务启动中")
 	fmt.Println("按 Ctrl+C 退出程序")
@@ -20,10 +88,38 @@ func main() {
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		sig := <-sigs
-		log.Printf("收到退出信号: %v", sig)
+		slog.Info("收到退出信号", "signal", sig.String())
 		done <- true
 	}()
-	log.Println("服务器运行中，等待退出信号")
+
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			if err := data.Reload(); err != nil {
+				slog.Warn("重新加载配置失败，继续使用旧配置", "err", err)
+			}
+		}
+	}()
+	slog.Info("服务器运行中，等待退出信号")
 	<-done
-	log.Println("正在退出")
+	slog.Info("正在退出")
+
+	grace := data.Get().ShutdownGrace.Std()
+	ctx, cancel := context.WithTimeout(context.Background(), grace+5*time.Second)
+	defer cancel()
+	go func() {
+		<-sigs
+		slog.Warn("再次收到退出信号，立即退出")
+		cancel()
+	}()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("关闭服务器未完全完成", "err", err)
+	}
+	adminServer.Shutdown(ctx)
+	if store := data.Players(); store != nil {
+		if err := store.Flush(); err != nil {
+			slog.Error("保存玩家存储失败", "err", err)
+		}
+	}
 }
@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// collector 是可以输出为 Prometheus 文本格式的指标
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// Default 为全局注册表，包级的 New* 函数都注册到这里
+var Default = NewRegistry()
+
+// register 按名称注册指标，同名指标会被替换
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[c.name()] = c
+}
+
+// WriteText 按名称顺序以文本格式输出所有指标
+func (r *Registry) WriteText(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
+
+type desc struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+}
+
+func (d *desc) name() string {
+	return d.metricName
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.metricName, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.metricName, d.kind)
+}
+
+type Counter struct {
+	desc
+	value atomic.Uint64
+}
+
+func NewCounter(name string, help string) *Counter {
+	c := &Counter{desc: desc{metricName: name, help: help, kind: "counter"}}
+	Default.register(c)
+	return c
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.writeHeader(w)
+	fmt.Fprintf(w, "%s %d\n", c.metricName, c.value.Load())
+}
+
+type Gauge struct {
+	desc
+	value atomic.Int64
+}
+
+func NewGauge(name string, help string) *Gauge {
+	g := &Gauge{desc: desc{metricName: name, help: help, kind: "gauge"}}
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+func (g *Gauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %d\n", g.metricName, g.value.Load())
+}
+
+// GaugeFunc 在输出时调用 fn 取值，适合反映已有状态（如信号量占用）
+type GaugeFunc struct {
+	desc
+	fn func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{metricName: name, help: help, kind: "gauge"}, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.fn()))
+}
+
+// CounterVec 是按标签值区分的一组计数器
+type CounterVec struct {
+	desc
+	mu       sync.RWMutex
+	counters map[string]*LabeledCounter
+}
+
+type LabeledCounter struct {
+	values []string
+	value  atomic.Uint64
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:     desc{metricName: name, help: help, kind: "counter", labels: labels},
+		counters: make(map[string]*LabeledCounter),
+	}
+	Default.register(c)
+	return c
+}
+
+// WithLabelValues 返回对应标签值的计数器，标签值数量必须与声明一致
+func (c *CounterVec) WithLabelValues(values ...string) *LabeledCounter {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("%s: 需要 %d 个标签值，实际为 %d", c.metricName, len(c.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	c.mu.RLock()
+	counter, ok := c.counters[key]
+	c.mu.RUnlock()
+	if ok {
+		return counter
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if counter, ok = c.counters[key]; !ok {
+		counter = &LabeledCounter{values: append([]string(nil), values...)}
+		c.counters[key] = counter
+	}
+	return counter
+}
+
+func (lc *LabeledCounter) Inc() {
+	lc.value.Add(1)
+}
+
+func (lc *LabeledCounter) Add(n uint64) {
+	lc.value.Add(n)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.writeHeader(w)
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.counters))
+	for key := range c.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counters := make([]*LabeledCounter, 0, len(keys))
+	for _, key := range keys {
+		counters = append(counters, c.counters[key])
+	}
+	c.mu.RUnlock()
+
+	for _, counter := range counters {
+		fmt.Fprintf(w, "%s%s %d\n", c.metricName, formatLabels(c.labels, counter.values), counter.value.Load())
+	}
+}
+
+// Histogram 统计观测值的分布，桶上界为累积计数
+type Histogram struct {
+	desc
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// DefaultLatencyBuckets 以秒为单位，覆盖局域网到跨洲连接
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &Histogram{
+		desc:    desc{metricName: name, help: help, kind: "histogram"},
+		buckets: sorted,
+		counts:  make([]uint64, len(sorted)),
+	}
+	Default.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	h.writeHeader(w)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatFloat(upper), counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, count)
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve 在 listen 上以 path 提供 Default 注册表的指标，返回的 http.Server 用于关闭
+func Serve(listen string, path string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET "+path, Default.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("指标接口已启动", "listen", listener.Addr().String(), "path", path)
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("指标接口异常退出", "err", err)
+		}
+	}()
+	return server, nil
+}
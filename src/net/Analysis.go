@@ -4,43 +4,70 @@ import (
 	"ShadowPlayer/src/io"
 	"ShadowPlayer/src/type"
 	"errors"
-	"net"
+	"fmt"
 	"strings"
-	"sync"
 )
 
 type Packet_160 struct {
-	clientVersion int32
-	queryString   string
-	playerName    string
+	CheckPacketName string `rw:"string"`
+	PacketVersion   int32  `rw:"int,version"`
+	ClientVersion   int32  `rw:"int"`
+	Reserved        []byte `rw:"skip=4,since=1"`
+	QueryString     string `rw:"isstring,since=2"`
+	PlayerName      string `rw:"string,since=3"`
 }
 
 type Packet_110 struct {
-	CheckPacketName     string
-	ClientPacketVersion int32
-	VersionA            int32
-	VersionB            int32
-	Name                string
-	PasswdHex           string
-	ClientPacketName    string
-	PlayerHex           string
-	UnitCheckSun        int32
-	KA                  string
-	KB                  string
+	CheckPacketName     string  `rw:"string"`
+	ClientPacketVersion int32   `rw:"int,version"`
+	VersionA            int32   `rw:"int"`
+	VersionB            int32   `rw:"int"`
+	Name                string  `rw:"string"`
+	PasswdHex           *string `rw:"isstring"`
+	ClientPacketName    string  `rw:"string"`
+	PlayerHex           string  `rw:"string"`
+	UnitCheckSun        int32   `rw:"int"`
+	KA                  string  `rw:"string"`
+	KB                  string  `rw:"string,since=5"`
 }
 
 type Packet_106 struct {
-	FirstString string
-	FirstInt    int32
-	MapType     int32
-	MapName     string
-	Credits     int32
-	Fog         int32
-	RevealedMap bool
-	AIDifficuly int32
-	InitUnit    int32
-	Income      float32
-	Nukes       bool
+	FirstString string  `rw:"string"`
+	FirstInt    int32   `rw:"int"`
+	MapType     int32   `rw:"int"`
+	MapName     string  `rw:"string"`
+	Credits     int32   `rw:"int"`
+	Fog         int32   `rw:"int"`
+	RevealedMap bool    `rw:"bool"`
+	AIDifficuly int32   `rw:"int"`
+	Format      byte    `rw:"byte,version"`
+	Reserved    []byte  `rw:"skip=2"`
+	Extended    []byte  `rw:"skip=8,since=1"`
+	InitUnit    int32   `rw:"int"`
+	Income      float32 `rw:"float"`
+	Nukes       bool    `rw:"bool"`
+	Remaining   []byte  `rw:"rest"`
+}
+
+type Packet_115 struct {
+	PlayerSize           int32  `rw:"int"`
+	RelayCustomMaxPlayer bool   `rw:"bool"`
+	MaxPlayerSize        int32  `rw:"int"`
+	TeamHead             string `rw:"string"`
+	TeamBlock            []byte `rw:"bytes"`
+	Fog                  int32  `rw:"int"`
+	Remaining            []byte `rw:"rest"`
+}
+
+type Packet_108 struct {
+	SendTime int64 `rw:"long"`
+	Flag     byte  `rw:"byte"`
+}
+
+type Packet_118 struct {
+	Flag       byte   `rw:"byte"`
+	QuestionID int32  `rw:"int"`
+	Answer     string `rw:"string"`
 }
 
 type PacketParseError struct {
@@ -67,32 +94,7 @@ func tryParse(fn func()) (err error) {
 }
 
 func Analysis_160(packet _type.Packet) (result Packet_160, err error) {
-	err = tryParse(func() {
-		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-		read.ReadString()
-		packetVersion, _ := read.ReadInt()
-		clientVersion, _ := read.ReadInt()
-
-		var queryString = ""
-		var playerName = ""
-
-		if packetVersion >= 1 {
-			read.Skip(4)
-		}
-		if packetVersion >= 2 {
-			queryString, _ = read.ReadIsString()
-		}
-		if packetVersion >= 3 {
-			playerName, _ = read.ReadString()
-		}
-
-		result = Packet_160{
-			clientVersion: clientVersion,
-			queryString:   queryString,
-			playerName:    playerName,
-		}
-	})
-
+	err = unmarshalPacket(packet, &result)
 	return
 }
 
@@ -101,17 +103,19 @@ func Analysis_140(packet _type.Packet) (result string, err error) {
 		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
 		result, _ = read.ReadString()
 	})
+	if err != nil {
+		observeParseError(packet.Type, "parse")
+	}
 
 	return
 }
 
 func Analysis_118(packet _type.Packet) (result string, err error) {
-	err = tryParse(func() {
-		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-		read.Skip(5)
-		result, _ = read.ReadString()
-		result = strings.TrimSpace(result)
-	})
+	var data Packet_118
+	if err = unmarshalPacket(packet, &data); err != nil {
+		return
+	}
+	result = strings.TrimSpace(data.Answer)
 	return
 }
 
@@ -155,19 +159,17 @@ func Creat_178(ip string) _type.Packet {
 	return result
 }
 
-func Creat_115(activeConnections sync.Map, conn net.Conn) _type.Packet {
+func Creat_115(playerNames []string) _type.Packet {
 	outputStreamFromBytesGzipBlock := io.NewGameOutputStreamFromBytes()
 
 	var playerSize = 0
 	var playerCount = 0
-	activeConnections.Range(func(key, value interface{}) bool {
+	for _, playerName := range playerNames {
 		playerCount++
-		if value != conn {
-			playerSize++
-		}
+		playerSize++
 
 		if playerCount <= 8 {
-			storedKey, _ := key.(string)
+			storedKey := playerName
 			outputStreamFromBytesGzipBlock.WriteBoolean(true)
 			outputStreamFromBytesGzipBlock.WriteInt(0)
 
@@ -183,8 +185,7 @@ func Creat_115(activeConnections sync.Map, conn net.Conn) _type.Packet {
 			outputStreamFromBytesGzipBlock.WriteBoolean(false)
 			outputStreamFromBytesGzipBlock.WriteInt(0)
 		}
-		return true
-	})
+	}
 
 	if playerCount <= 8 {
 		diff := 8 - playerCount
@@ -227,121 +228,37 @@ func Creat_141_System(msg string) _type.Packet {
 }
 
 func Analysis_108(packet _type.Packet) (sendTime int64, err error) {
-	err = tryParse(func() {
-		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-		sendTime, _ = read.ReadLong()
-	})
+	var data Packet_108
+	err = unmarshalPacket(packet, &data)
+	sendTime = data.SendTime
 	return
 }
 
-func Creat_108() _type.Packet {
-	outputStreamFromBytes := io.NewGameOutputStreamFromBytes()
-	outputStreamFromBytes.WriteLong(0)
-	outputStreamFromBytes.WriteByte(0)
-	result, _ := outputStreamFromBytes.CreatePacket(108)
-	return result
+func Creat_108(sendTime int64) _type.Packet {
+	return marshalPacket(_type.PacketHeartBeat, Packet_108{SendTime: sendTime})
 }
 
 func Creat_109(sendTime int64) _type.Packet {
-	outputStreamFromBytes := io.NewGameOutputStreamFromBytes()
-	outputStreamFromBytes.WriteLong(sendTime)
-	outputStreamFromBytes.WriteByte(0)
-	result, _ := outputStreamFromBytes.CreatePacket(109)
-	return result
+	return marshalPacket(_type.PacketHeartBeatResponse, Packet_108{SendTime: sendTime})
 }
 
 func Analysis_110(packet _type.Packet) (result Packet_110, err error) {
-	err = tryParse(func() {
-		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-		checkPacketName, _ := read.ReadString()
-		clientPacketVersion, _ := read.ReadInt()
-		versionA, _ := read.ReadInt()
-		versionB, _ := read.ReadInt()
-		name, _ := read.ReadString()
-		passwdHex, _ := read.ReadIsString()
-		clientPacketName, _ := read.ReadString()
-		playerHex, _ := read.ReadString()
-		unitCheckSun, _ := read.ReadInt()
-		ka, _ := read.ReadString()
-
-		var kb string = ""
-		if clientPacketVersion >= 5 {
-			kb, _ = read.ReadString()
-		}
-
-		result = Packet_110{
-			CheckPacketName:     checkPacketName,
-			ClientPacketVersion: clientPacketVersion,
-			VersionA:            versionA,
-			VersionB:            versionB,
-			Name:                name,
-			PasswdHex:           passwdHex,
-			ClientPacketName:    clientPacketName,
-			PlayerHex:           playerHex,
-			UnitCheckSun:        unitCheckSun,
-			KA:                  ka,
-			KB:                  kb,
-		}
-	})
+	err = unmarshalPacket(packet, &result)
 	return
 }
 
 func Creat_110(data Packet_110) _type.Packet {
-	outputStreamFromBytes := io.NewGameOutputStreamFromBytes()
-	outputStreamFromBytes.WriteString(data.CheckPacketName)
-	outputStreamFromBytes.WriteInt(data.ClientPacketVersion)
-	outputStreamFromBytes.WriteInt(data.VersionA)
-	outputStreamFromBytes.WriteInt(data.VersionB)
-	outputStreamFromBytes.WriteString(data.Name)
-	outputStreamFromBytes.WriteIsString(&data.PasswdHex)
-	outputStreamFromBytes.WriteString(data.ClientPacketName)
-	outputStreamFromBytes.WriteString(data.PlayerHex)
-	outputStreamFromBytes.WriteInt(data.UnitCheckSun)
-	outputStreamFromBytes.WriteString(data.KA)
-	if data.ClientPacketVersion >= 5 {
-		outputStreamFromBytes.WriteString(data.KB)
-	}
-	result, _ := outputStreamFromBytes.CreatePacket(110)
-	return result
+	return marshalPacket(_type.PacketRegisterPlayer, data)
 }
 
 func Analysis_106(packet _type.Packet) (result Packet_106, err error) {
-	err = tryParse(func() {
-		read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-		firstString, _ := read.ReadString()
-		firstInt, _ := read.ReadInt()
-		mapType, _ := read.ReadInt()
-		mapName, _ := read.ReadString()
-		credits, _ := read.ReadInt()
-		fog, _ := read.ReadInt()
-		revealedMap, _ := read.ReadBoolean()
-		aiDifficuly, _ := read.ReadInt()
-		readByte, _ := read.ReadByte()
-		read.Skip(2)
-		if readByte >= 1 {
-			read.Skip(8)
-		}
-		if readByte < 2 {
-			panic(errors.New("readByte must be >= 2"))
-		}
-		initUnit, _ := read.ReadInt()
-		income, _ := read.ReadFloat()
-		nukes, _ := read.ReadBoolean()
-
-		result = Packet_106{
-			FirstString: firstString,
-			FirstInt:    firstInt,
-			MapType:     mapType,
-			MapName:     mapName,
-			Credits:     credits,
-			Fog:         fog,
-			RevealedMap: revealedMap,
-			AIDifficuly: aiDifficuly,
-			InitUnit:    initUnit,
-			Income:      income,
-			Nukes:       nukes,
-		}
-	})
+	if err = unmarshalPacket(packet, &result); err != nil {
+		return
+	}
+	if result.Format < 2 {
+		observeParseError(packet.Type, "parse")
+		err = &PacketParseError{Op: "packet parse", Err: errors.New("readByte must be >= 2")}
+	}
 	return
 }
 
@@ -350,55 +267,23 @@ func Creat_106_ModifyFog(packet _type.Packet, isFog bool) (_type.Packet, error)
 		return packet, nil
 	}
 
-	read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-	output := io.NewGameOutputStreamFromBytes()
-
-	firstString, _ := read.ReadString()
-	output.WriteString(firstString)
-
-	firstInt, _ := read.ReadInt()
-	output.WriteInt(firstInt)
-
-	mapType, _ := read.ReadInt()
-	output.WriteInt(mapType)
-
-	mapName, _ := read.ReadString()
-	output.WriteString(mapName)
-
-	credits, _ := read.ReadInt()
-	output.WriteInt(credits)
-
-	_, _ = read.ReadInt()
-	output.WriteInt(0)
-
-	revealedMap, _ := read.ReadBoolean()
-	output.WriteBoolean(revealedMap)
-
-	aiDifficuly, _ := read.ReadInt()
-	output.WriteInt(aiDifficuly)
-
-	readByte, _ := read.ReadByte()
-	output.WriteByte(readByte)
-
-	output.TransferToFixedLength(read, 2)
-
-	if readByte >= 1 {
-		output.TransferToFixedLength(read, 8)
+	var data Packet_106
+	if err := unmarshalPacket(packet, &data); err != nil {
+		return packet, err
 	}
+	data.Fog = 0
 
-	initUnit, _ := read.ReadInt()
-	output.WriteInt(initUnit)
-
-	income, _ := read.ReadFloat()
-	output.WriteFloat(income)
-
-	nukes, _ := read.ReadBoolean()
-	output.WriteBoolean(nukes)
-
-	output.TransferTo(read)
+	bytes, err := io.Marshal(data)
+	if err != nil {
+		observeParseError(packet.Type, "modify")
+		return packet, err
+	}
+	return _type.Packet{Type: packet.Type, Bytes: bytes}, nil
+}
 
-	result, err := output.CreatePacket(106)
-	return result, err
+func Analysis_115(packet _type.Packet) (result Packet_115, err error) {
+	err = unmarshalPacket(packet, &result)
+	return
 }
 
 func Creat_115_Modify(packet _type.Packet, isFog bool) (_type.Packet, error) {
@@ -406,31 +291,132 @@ func Creat_115_Modify(packet _type.Packet, isFog bool) (_type.Packet, error) {
 		return packet, nil
 	}
 
-	read := io.NewGameInputStreamFromBytes(packet.Bytes, 0)
-	output := io.NewGameOutputStreamFromBytes()
-
-	playerSize, _ := read.ReadInt()
-	output.WriteInt(playerSize)
-
-	relayCustomMaxPlayer, _ := read.ReadBoolean()
-	output.WriteBoolean(relayCustomMaxPlayer)
-
-	maxPlayerSize, _ := read.ReadInt()
-	output.WriteInt(maxPlayerSize)
-
-	head, _ := read.ReadString()
-	output.WriteString(head)
+	data, err := Analysis_115(packet)
+	if err != nil {
+		return packet, err
+	}
+	data.Fog = 0
 
-	gzipBlockLength, _ := read.ReadInt()
-	output.WriteInt(gzipBlockLength)
+	bytes, err := io.Marshal(data)
+	if err != nil {
+		observeParseError(packet.Type, "modify")
+		return packet, err
+	}
+	return _type.Packet{Type: packet.Type, Bytes: bytes}, nil
+}
 
-	output.TransferToFixedLength(read, int(gzipBlockLength))
+func unmarshalPacket(packet _type.Packet, v interface{}) error {
+	var err error
+	if recovered := tryParse(func() {
+		err = io.Unmarshal(io.NewGameInputStreamFromBytes(packet.Bytes, 0), v)
+	}); recovered != nil {
+		observeParseError(packet.Type, "parse")
+		return recovered
+	}
+	if err != nil {
+		observeParseError(packet.Type, "parse")
+		return &PacketParseError{Op: fmt.Sprintf("%v parse", packet.Type), Err: err}
+	}
+	return nil
+}
 
-	_, _ = read.ReadInt()
-	output.WriteInt(0)
+func marshalPacket(packetType _type.PacketType, v interface{}) _type.Packet {
+	bytes, _ := io.Marshal(v)
+	return _type.Packet{Type: packetType, Bytes: bytes}
+}
 
-	output.TransferTo(read)
+func init() {
+	_type.RegisterCodec(_type.PacketPreregisterInfo,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_160(packet) },
+		nil)
+	_type.RegisterCodec(_type.PacketPreregisterInfoResponse,
+		nil,
+		func(interface{}) (_type.Packet, error) { return Creat_161(), nil })
+	_type.RegisterCodec(_type.PacketRegisterPlayer,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_110(packet) },
+		func(value interface{}) (_type.Packet, error) {
+			data, ok := value.(Packet_110)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketRegisterPlayer, value)
+			}
+			return Creat_110(data), nil
+		})
+	_type.RegisterCodec(_type.PacketServerInfo,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_106(packet) },
+		func(value interface{}) (_type.Packet, error) {
+			data, ok := value.(Packet_106)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketServerInfo, value)
+			}
+			bytes, err := io.Marshal(data)
+			return _type.Packet{Type: _type.PacketServerInfo, Bytes: bytes}, err
+		})
+	_type.RegisterCodec(_type.PacketTeamList,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_115(packet) },
+		func(value interface{}) (_type.Packet, error) {
+			data, ok := value.(Packet_115)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketTeamList, value)
+			}
+			bytes, err := io.Marshal(data)
+			return _type.Packet{Type: _type.PacketTeamList, Bytes: bytes}, err
+		})
+	_type.RegisterCodec(_type.PacketHeartBeat,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_108(packet) },
+		func(value interface{}) (_type.Packet, error) {
+			sendTime, ok := value.(int64)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketHeartBeat, value)
+			}
+			return Creat_108(sendTime), nil
+		})
+	_type.RegisterCodec(_type.PacketHeartBeatResponse,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_108(packet) },
+		func(value interface{}) (_type.Packet, error) {
+			sendTime, ok := value.(int64)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketHeartBeatResponse, value)
+			}
+			return Creat_109(sendTime), nil
+		})
+	_type.RegisterCodec(_type.PacketPasswdError,
+		nil,
+		func(interface{}) (_type.Packet, error) { return Creat_113(), nil })
+	_type.RegisterCodec(_type.PacketQuestion,
+		nil,
+		func(value interface{}) (_type.Packet, error) {
+			msg, ok := value.(string)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketQuestion, value)
+			}
+			return Creat_117(msg), nil
+		})
+	_type.RegisterCodec(_type.PacketQuestionResponse,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_118(packet) },
+		nil)
+	_type.RegisterCodec(_type.PacketChatReceive,
+		func(packet _type.Packet) (interface{}, error) { return Analysis_140(packet) },
+		nil)
+	_type.RegisterCodec(_type.PacketChat,
+		nil,
+		func(value interface{}) (_type.Packet, error) {
+			msg, ok := value.(string)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketChat, value)
+			}
+			return Creat_141_System(msg), nil
+		})
+	_type.RegisterCodec(_type.PacketReconnectTo,
+		nil,
+		func(value interface{}) (_type.Packet, error) {
+			ip, ok := value.(string)
+			if !ok {
+				return _type.Packet{}, encodeTypeError(_type.PacketReconnectTo, value)
+			}
+			return Creat_178(ip), nil
+		})
+}
 
-	result, err := output.CreatePacket(115)
-	return result, err
+func encodeTypeError(packetType _type.PacketType, value interface{}) error {
+	return fmt.Errorf("%v 编码参数类型错误: %T", packetType, value)
 }
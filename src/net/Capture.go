@@ -0,0 +1,173 @@
+package net
+
+import (
+	"ShadowPlayer/src/capture"
+	"ShadowPlayer/src/data"
+	_type "ShadowPlayer/src/type"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// captureOverrides 保存管理接口临时开关的玩家，优先于配置中的 capture.players
+var captureOverrides = struct {
+	mu      sync.Mutex
+	players map[string]bool
+}{players: make(map[string]bool)}
+
+func init() {
+	data.OnReload(func(old, new *data.Config) {
+		for _, connData := range registry.players() {
+			connData.syncCapture()
+		}
+	})
+}
+
+func captureWanted(playerName string) bool {
+	if playerName == "" {
+		return false
+	}
+	captureOverrides.mu.Lock()
+	enabled, ok := captureOverrides.players[playerName]
+	captureOverrides.mu.Unlock()
+	if ok {
+		return enabled
+	}
+	return slices.Contains(data.Get().Capture.Players, playerName)
+}
+
+// record 在开启抓包时记录一个数据包
+func (cd *ConnectionData) record(dir capture.Direction, packet _type.Packet) {
+	if session := cd.capture.Load(); session != nil {
+		session.Record(dir, packet)
+	}
+}
+
+// syncCapture 按当前配置开始或停止抓包，返回是否新开始了抓包
+func (cd *ConnectionData) syncCapture() bool {
+	playerName := cd.GetPlayerName()
+	wanted := captureWanted(playerName)
+	current := cd.capture.Load()
+	switch {
+	case wanted && current == nil:
+		return cd.startCapture(playerName)
+	case !wanted && current != nil:
+		cd.stopCapture()
+	}
+	return false
+}
+
+func (cd *ConnectionData) startCapture(playerName string) bool {
+	dir := data.Get().Capture.Dir
+	if dir == "" {
+		cd.Logger().Warn("未配置 capture.dir，无法抓包")
+		return false
+	}
+
+	var clientPort, listenPort uint16
+	if addr, ok := cd.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		clientPort = uint16(addr.Port)
+	}
+	if addr, ok := cd.Conn.LocalAddr().(*net.TCPAddr); ok {
+		listenPort = uint16(addr.Port)
+	}
+	comment := fmt.Sprintf("session %s player %s client %s", cd.ID, playerName, cd.Conn.RemoteAddr())
+	name := captureFileName(playerName) + "-" + cd.ID[:8]
+
+	session, err := capture.Create(data.ResolvePath(dir), name, clientPort, listenPort, comment)
+	if err != nil {
+		cd.Logger().Warn("无法创建抓包文件", "err", err)
+		return false
+	}
+	if !cd.capture.CompareAndSwap(nil, session) {
+		session.Close()
+		return false
+	}
+	cd.Logger().Info("开始抓包", "path", session.Path())
+
+	cd.mu.RLock()
+	proxy := cd.proxy
+	cd.mu.RUnlock()
+	if proxy != nil && proxy.IsConnected() {
+		cd.captureTarget(proxy)
+	}
+	return true
+}
+
+// captureTarget 在抓包中开始一条到目标服务器的虚拟连接
+func (cd *ConnectionData) captureTarget(proxy *ProxyConnection) {
+	session := cd.capture.Load()
+	if session == nil {
+		return
+	}
+	host, port := cd.GetIP(), cd.GetPort()
+	target := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	session.SetTarget(uint16(port), fmt.Sprintf("target %s remote %s", target, proxy.RemoteAddr()))
+}
+
+func (cd *ConnectionData) stopCapture() {
+	session := cd.capture.Swap(nil)
+	if session == nil {
+		return
+	}
+	if err := session.Close(); err != nil {
+		cd.Logger().Warn("抓包文件写入失败", "path", session.Path(), "err", err)
+		return
+	}
+	cd.Logger().Info("停止抓包", "path", session.Path())
+}
+
+// captureFileName 把玩家名转换为可以安全用作文件名的形式
+func captureFileName(playerName string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, playerName)
+}
+
+// SetCapture 开关指定玩家的抓包，对在线会话立即生效，返回该玩家当前是否正在抓包
+func (s *Server) SetCapture(playerName string, enabled bool) (bool, error) {
+	if enabled && data.Get().Capture.Dir == "" {
+		return false, errors.New("未配置 capture.dir")
+	}
+	captureOverrides.mu.Lock()
+	captureOverrides.players[playerName] = enabled
+	captureOverrides.mu.Unlock()
+
+	connData, ok := GetConnectionData(playerName)
+	if !ok {
+		return false, nil
+	}
+	connData.syncCapture()
+	return connData.capture.Load() != nil, nil
+}
+
+// CapturePlayers 返回当前需要抓包的玩家
+func (s *Server) CapturePlayers() []string {
+	players := make(map[string]bool)
+	for _, name := range data.Get().Capture.Players {
+		players[name] = true
+	}
+	captureOverrides.mu.Lock()
+	for name, enabled := range captureOverrides.players {
+		players[name] = enabled
+	}
+	captureOverrides.mu.Unlock()
+
+	result := make([]string, 0, len(players))
+	for name, enabled := range players {
+		if enabled {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,198 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// CommandHandler 处理一条聊天指令，args 为指令名之后的文本。
+// 返回值会作为系统消息私下发给该玩家，为空时不回复
+type CommandHandler func(connData *ConnectionData, args string) string
+
+type chatCommand struct {
+	name        string
+	description string
+	handler     CommandHandler
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   []chatCommand // 按注册顺序排列，help 中也按此顺序显示
+)
+
+func init() {
+	mustRegisterCommand("help", "列出可用的指令", commandHelp)
+	mustRegisterCommand("ping", "查看你到 ShadowPlayer 以及 ShadowPlayer 到目标服务器的延迟", commandPing)
+	mustRegisterCommand("info", "查看目标服务器、连接时长和流量", commandInfo)
+	mustRegisterCommand("fog", "查看当前的去雾设置", commandFog)
+	mustRegisterCommand("disconnect", "断开目标服务器并回到 ShadowPlayer 大厅", commandDisconnect)
+}
+
+// RegisterCommand 注册一条聊天指令，指令名不区分大小写。
+// 只回复固定文本的指令也可以写在配置的 commands.custom 中，无需修改代码
+func RegisterCommand(name string, description string, handler CommandHandler) error {
+	name = strings.ToLower(name)
+	if name == "" || strings.ContainsFunc(name, unicode.IsSpace) {
+		return fmt.Errorf("指令名不能为空或包含空白字符: %q", name)
+	}
+	if handler == nil {
+		return errors.New("指令处理函数不能为空")
+	}
+
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	for _, command := range commands {
+		if command.name == name {
+			return fmt.Errorf("指令 %s 已存在", name)
+		}
+	}
+	commands = append(commands, chatCommand{name: name, description: description, handler: handler})
+	return nil
+}
+
+func mustRegisterCommand(name string, description string, handler CommandHandler) {
+	if err := RegisterCommand(name, description, handler); err != nil {
+		panic(err)
+	}
+}
+
+func lookupCommand(name string) (chatCommand, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	for _, command := range commands {
+		if command.name == name {
+			return command, true
+		}
+	}
+	return chatCommand{}, false
+}
+
+// lookupCustomCommand 查找配置中的指令，随配置热重载
+func lookupCustomCommand(name string) (data.CustomCommand, bool) {
+	for _, command := range data.Get().Commands.Custom {
+		if strings.ToLower(command.Name) == name {
+			return command, true
+		}
+	}
+	return data.CustomCommand{}, false
+}
+
+// parseCommand 判断聊天是否为指令。只输入前缀时视为 help
+func parseCommand(msg string) (name string, args string, ok bool) {
+	prefix := data.Get().Commands.Prefix
+	if prefix == "" {
+		return "", "", false
+	}
+	msg = strings.TrimSpace(msg)
+	rest, found := strings.CutPrefix(msg, prefix)
+	if !found {
+		return "", "", false
+	}
+	if rest == "" {
+		return "help", "", true
+	}
+	if !unicode.IsSpace([]rune(rest)[0]) {
+		return "", "", false
+	}
+	rest = strings.TrimSpace(rest)
+	if i := strings.IndexFunc(rest, unicode.IsSpace); i >= 0 {
+		name, args = rest[:i], strings.TrimSpace(rest[i:])
+	} else {
+		name = rest
+	}
+	return strings.ToLower(name), args, true
+}
+
+// runCommand 执行指令并私下回复玩家
+func runCommand(connData *ConnectionData, name string, args string) {
+	connData.Logger().Info("玩家执行指令", "command", name, "args", args)
+
+	var reply string
+	if command, ok := lookupCommand(name); ok {
+		reply = command.handler(connData, args)
+	} else if custom, ok := lookupCustomCommand(name); ok {
+		reply = custom.Reply
+	} else {
+		reply = fmt.Sprintf("未知指令 %s\n输入 %s help 查看可用的指令", name, data.Get().Commands.Prefix)
+	}
+	if reply != "" {
+		sendBinaryResponse0(connData, Creat_141_System(reply))
+	}
+}
+
+func commandHelp(connData *ConnectionData, args string) string {
+	prefix := data.Get().Commands.Prefix
+	var sb strings.Builder
+	sb.WriteString("ShadowPlayer 指令")
+
+	commandsMu.RLock()
+	for _, command := range commands {
+		fmt.Fprintf(&sb, "\n%s %s - %s", prefix, command.name, command.description)
+	}
+	commandsMu.RUnlock()
+
+	for _, custom := range data.Get().Commands.Custom {
+		name := strings.ToLower(custom.Name)
+		if _, ok := lookupCommand(name); ok {
+			continue
+		}
+		fmt.Fprintf(&sb, "\n%s %s", prefix, name)
+		if custom.Description != "" {
+			fmt.Fprintf(&sb, " - %s", custom.Description)
+		}
+	}
+	return sb.String()
+}
+
+func commandPing(connData *ConnectionData, args string) string {
+	return connData.pingReport()
+}
+
+func commandInfo(connData *ConnectionData, args string) string {
+	info := connData.Info()
+	var sb strings.Builder
+	sb.WriteString("会话信息\n")
+	if info.Target != "" {
+		fmt.Fprintf(&sb, "目标服务器: %s\n", info.Target)
+	}
+	if info.RemoteAddr != "" {
+		fmt.Fprintf(&sb, "实际连接地址: %s\n", info.RemoteAddr)
+	}
+	if info.Egress != "" {
+		fmt.Fprintf(&sb, "出口地址: %s\n", info.Egress)
+	}
+	fmt.Fprintf(&sb, "连接时长: %s\n", time.Since(info.ConnectedAt).Round(time.Second))
+	fmt.Fprintf(&sb, "发送: %d 个包 %s\n", info.Upstream.Packets, formatBytes(info.Upstream.Bytes))
+	fmt.Fprintf(&sb, "接收: %d 个包 %s", info.Downstream.Packets, formatBytes(info.Downstream.Bytes))
+	return sb.String()
+}
+
+func commandFog(connData *ConnectionData, args string) string {
+	if connData.GetIsFog() {
+		return "去雾: 已启用"
+	}
+	return "去雾: 未启用"
+}
+
+func commandDisconnect(connData *ConnectionData, args string) string {
+	sendBinaryResponse0(connData, Creat_141_System("已断开目标服务器，正在返回 ShadowPlayer 大厅"))
+	returnToLobby(connData)
+	return ""
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
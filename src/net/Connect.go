@@ -0,0 +1,202 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 单次连接超过该时间仍未完成时才提示玩家，避免正常连接时闪出对话框
+const dialProgressDelay = time.Second
+
+// dialFailure 是连接目标服务器失败的分类
+type dialFailure struct {
+	kind    string // refused、timeout、dns、upstream、policy、canceled、error，同时用作指标标签
+	message string // 给玩家看的说明
+	retry   bool   // 是否值得重试
+}
+
+func classifyDialError(err error) dialFailure {
+	var policyErr *PolicyError
+	var dnsErr *DNSError
+	var netDNSErr *net.DNSError
+	var upstreamErr *UpstreamError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &policyErr):
+		return dialFailure{kind: "policy", message: "该地址不允许通过 ShadowPlayer 访问：" + policyErr.Reason}
+	case errors.Is(err, context.Canceled):
+		return dialFailure{kind: "canceled", message: "已取消连接"}
+	case errors.As(err, &dnsErr):
+		if errors.As(err, &netDNSErr) && netDNSErr.IsNotFound {
+			return dialFailure{kind: "dns", message: fmt.Sprintf("域名 %s 不存在", dnsErr.Host)}
+		}
+		return dialFailure{kind: "dns", message: fmt.Sprintf("无法解析域名 %s", dnsErr.Host), retry: true}
+	case errors.As(err, &upstreamErr):
+		// 认证失败重试也不会成功
+		return dialFailure{kind: "upstream", message: upstreamErr.Error(), retry: upstreamErr.Stage != upstreamStageAuth}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialFailure{kind: "refused", message: "目标服务器拒绝连接，可能端口错误或服务器未启动", retry: true}
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return dialFailure{kind: "timeout", message: fmt.Sprintf("连接超时（%s 内没有响应）", data.Get().ProxyDialTimeout), retry: true}
+	}
+	return dialFailure{kind: "error", message: "网络错误，目标服务器无法访问", retry: true}
+}
+
+var (
+	errDialCanceled = errors.New("玩家取消连接")
+	errSessionEnded = errors.New("会话已结束")
+)
+
+// pendingDial 是一次在后台进行的连接（含重试）
+type pendingDial struct {
+	cancel context.CancelCauseFunc
+}
+
+// cancelDial 以 cause 取消正在进行的连接，没有连接时返回 false
+func (cd *ConnectionData) cancelDial(cause error) bool {
+	cd.mu.Lock()
+	dial := cd.dialing
+	cd.dialing = nil
+	cd.mu.Unlock()
+	if dial == nil {
+		return false
+	}
+	dial.cancel(cause)
+	return true
+}
+
+// startProxyFromLobby 在后台连接玩家选择的目标服务器，失败时按 dial 配置重试。
+// 连接期间客户端照常收发，玩家在进度对话框中输入任意内容即可取消
+func startProxyFromLobby(connData *ConnectionData, playerName string) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	dial := &pendingDial{cancel: cancel}
+	connData.mu.Lock()
+	if connData.dialing != nil {
+		connData.mu.Unlock()
+		cancel(nil)
+		return
+	}
+	connData.dialing = dial
+	connData.mu.Unlock()
+
+	go func() {
+		err := dialWithRetry(ctx, connData, playerName)
+		connData.mu.Lock()
+		if connData.dialing == dial {
+			connData.dialing = nil
+		}
+		connData.mu.Unlock()
+		cancel(nil)
+
+		if errors.Is(context.Cause(ctx), errSessionEnded) {
+			return
+		}
+		if err != nil {
+			reportDialFailure(connData, err)
+			return
+		}
+		proxyStarted(connData, playerName)
+	}()
+}
+
+func dialWithRetry(ctx context.Context, connData *ConnectionData, playerName string) error {
+	config := data.Get().Dial
+	target := formatTarget(connData.GetIP(), connData.GetPort(), connData.GetIsFog())
+	backoff := config.InitialBackoff.Std()
+
+	for attempt := 1; ; attempt++ {
+		// 计时器回调可能在 Stop 之后仍在运行，持锁检查 finished，保证尝试结束后不再发出进度对话框
+		var progressMu sync.Mutex
+		finished := false
+		progress := time.AfterFunc(dialProgressDelay, func() {
+			progressMu.Lock()
+			defer progressMu.Unlock()
+			if finished {
+				return
+			}
+			sendBinaryResponse0(connData, Creat_117(fmt.Sprintf(
+				"正在连接 %s\n第 %d/%d 次尝试\n\n输入任意内容取消", target, attempt, config.Attempts)))
+		})
+		err := StartProxyForPlayer(ctx, playerName)
+		progressMu.Lock()
+		finished = true
+		progressMu.Unlock()
+		progress.Stop()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		failure := classifyDialError(err)
+		if !failure.retry || attempt >= config.Attempts {
+			return err
+		}
+		connData.Logger().Info("等待后重试连接目标服务器", "attempt", attempt, "attempts", config.Attempts, "reason", failure.kind, "backoff", backoff)
+
+		sendBinaryResponse0(connData, Creat_117(fmt.Sprintf(
+			"连接 %s 失败\n原因：%s\n\n%v 后进行第 %d/%d 次尝试，输入任意内容取消",
+			target, failure.message, backoff, attempt+1, config.Attempts)))
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, config.MaxBackoff.Std())
+	}
+}
+
+func reportDialFailure(connData *ConnectionData, err error) {
+	failure := classifyDialError(err)
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		connData.Logger().Warn("目标被策略拒绝", "err", err)
+		connData.SetIP("")
+		connData.SetPort(0)
+		sendBinaryResponse0(connData, Creat_117(fmt.Sprintf(
+			`无法代理到该服务器
+
+%s
+原因：%s
+
+该地址不允许通过 ShadowPlayer 访问，请重新输入服务器地址：`, policyErr.Target, policyErr.Reason)))
+		return
+	}
+	if failure.kind == "canceled" {
+		connData.Logger().Info("玩家取消连接目标服务器")
+		connData.offerReconnect(failure.message, "")
+		return
+	}
+	connData.Logger().Warn("启动代理失败", "reason", failure.kind, "err", err)
+	connData.offerReconnect("无法连接到目标服务器", failure.message+"\n"+describeDialFailure(err))
+}
+
+// proxyStarted 记录玩家的连接并把保存的 160 包转发给目标服务器，开始正常的握手
+func proxyStarted(connData *ConnectionData, playerName string) {
+	connData.Logger().Info("代理已启动")
+	if store := data.Players(); store != nil {
+		key := playerStoreKey(connData, playerName)
+		if err := store.RecordTarget(key, connData.GetIP(), connData.GetPort(), connData.GetIsFog()); err != nil {
+			connData.Logger().Warn("保存连接记录失败", "err", err)
+		} else {
+			markPINOwner(connData, playerName)
+		}
+	}
+	connData.mu.RLock()
+	proxy := connData.proxy
+	savedPacket160 := connData.packet160
+	connData.mu.RUnlock()
+
+	if proxy != nil && savedPacket160 != nil {
+		proxy.ForwardPacket(*savedPacket160)
+	}
+}
@@ -0,0 +1,221 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	happyEyeballsDelay = 250 * time.Millisecond
+	negativeCacheTTL   = 5 * time.Second
+)
+
+type dnsCacheEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+var dnsCache = struct {
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}{entries: make(map[string]dnsCacheEntry)}
+
+func init() {
+	data.OnReload(func(old, new *data.Config) {
+		dnsCache.mu.Lock()
+		dnsCache.entries = make(map[string]dnsCacheEntry)
+		dnsCache.mu.Unlock()
+	})
+}
+
+// lookupHost 依次查询 IP 字面量、静态主机表、DNS 缓存，最后才发起 DNS 请求
+func lookupHost(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	config := data.Get()
+	name := normalizeHost(host)
+	for key, values := range config.Hosts {
+		if normalizeHost(key) != name {
+			continue
+		}
+		addrs := make([]netip.Addr, 0, len(values))
+		for _, value := range values {
+			if addr, err := netip.ParseAddr(value); err == nil {
+				addrs = append(addrs, addr.Unmap())
+			}
+		}
+		return addrs, nil
+	}
+
+	ttl := config.DNSCacheTTL.Std()
+	if ttl > 0 {
+		dnsCache.mu.Lock()
+		entry, ok := dnsCache.entries[name]
+		dnsCache.mu.Unlock()
+		if ok && time.Now().Before(entry.expires) {
+			return entry.addrs, entry.err
+		}
+	}
+
+	resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", name)
+	addrs := make([]netip.Addr, 0, len(resolved))
+	for _, addr := range resolved {
+		addrs = append(addrs, addr.Unmap())
+	}
+	if err != nil {
+		err = &DNSError{Host: host, Err: err}
+	}
+
+	if ttl > 0 && ctx.Err() == nil {
+		entryTTL := ttl
+		if err != nil {
+			entryTTL = min(ttl, negativeCacheTTL)
+		}
+		dnsCache.mu.Lock()
+		dnsCache.entries[name] = dnsCacheEntry{addrs: addrs, err: err, expires: time.Now().Add(entryTTL)}
+		dnsCache.mu.Unlock()
+	}
+	return addrs, err
+}
+
+type DNSError struct {
+	Host string
+	Err  error
+}
+
+func (e *DNSError) Error() string {
+	return fmt.Sprintf("解析 %s 失败: %v", e.Host, e.Err)
+}
+
+func (e *DNSError) Unwrap() error {
+	return e.Err
+}
+
+type dialAttempt struct {
+	Addr netip.AddrPort
+	Err  error
+}
+
+type DialError struct {
+	Attempts []dialAttempt
+}
+
+func (e *DialError) Error() string {
+	parts := make([]string, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		parts = append(parts, fmt.Sprintf("%s: %v", attempt.Addr, attempt.Err))
+	}
+	return "全部地址连接失败 (" + strings.Join(parts, "; ") + ")"
+}
+
+func (e *DialError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Attempts))
+	for _, attempt := range e.Attempts {
+		errs = append(errs, attempt.Err)
+	}
+	return errs
+}
+
+// interleaveFamilies 按 RFC 8305 交替排列 IPv6 与 IPv4 地址，首个地址的协议族优先
+func interleaveFamilies(addrs []netip.Addr) []netip.Addr {
+	var v6, v4 []netip.Addr
+	for _, addr := range addrs {
+		if addr.Is4() {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+	first, second := v6, v4
+	if len(addrs) > 0 && addrs[0].Is4() {
+		first, second = v4, v6
+	}
+	result := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			result = append(result, first[i])
+		}
+		if i < len(second) {
+			result = append(result, second[i])
+		}
+	}
+	return result
+}
+
+// dialHappyEyeballs 依次用 dial 发起连接，每次间隔 happyEyeballsDelay 或在上一个尝试失败时立即开始下一个，
+// 返回最先建立的连接，其余连接会被取消或关闭
+func dialHappyEyeballs(ctx context.Context, addrs []netip.Addr, port uint16,
+	dial func(ctx context.Context, addr netip.AddrPort) (net.Conn, error)) (net.Conn, netip.AddrPort, error) {
+	if len(addrs) == 0 {
+		return nil, netip.AddrPort{}, errors.New("没有可连接的地址")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		addr netip.AddrPort
+		err  error
+	}
+
+	ordered := interleaveFamilies(addrs)
+	results := make(chan result, len(ordered))
+	startAttempt := func(addr netip.AddrPort) {
+		go func() {
+			conn, err := dial(ctx, addr)
+			results <- result{conn: conn, addr: addr, err: err}
+		}()
+	}
+
+	next, pending := 0, 0
+	launch := func() {
+		startAttempt(netip.AddrPortFrom(ordered[next], port))
+		next++
+		pending++
+	}
+	launch()
+
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+
+	var dialErr DialError
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				cancel()
+				go func(remaining int) {
+					for ; remaining > 0; remaining-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, res.addr, nil
+			}
+			dialErr.Attempts = append(dialErr.Attempts, dialAttempt{Addr: res.addr, Err: res.err})
+			if next < len(ordered) && ctx.Err() == nil {
+				launch()
+				timer.Reset(happyEyeballsDelay)
+			}
+		case <-timer.C:
+			if next < len(ordered) {
+				launch()
+				timer.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+	return nil, netip.AddrPort{}, &dialErr
+}
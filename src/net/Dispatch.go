@@ -0,0 +1,217 @@
+package net
+
+import (
+	"ShadowPlayer/src/capture"
+	_type "ShadowPlayer/src/type"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+type proxiedHandler func(connData *ConnectionData, proxy *ProxyConnection, packet _type.Packet)
+type lobbyHandler func(connData *ConnectionData, packet _type.Packet)
+type downstreamHandler func(connData *ConnectionData, packet _type.Packet) _type.Packet
+
+var (
+	// 代理已建立时，客户端发往目标服务器的包；未登记的类型直接转发
+	proxiedHandlers map[_type.PacketType]proxiedHandler
+	// 代理建立前，由 ShadowPlayer 自己应答的包；未登记的类型直接丢弃
+	lobbyHandlers map[_type.PacketType]lobbyHandler
+	// 目标服务器发往客户端的包；返回值为实际发给客户端的包
+	downstreamHandlers map[_type.PacketType]downstreamHandler
+)
+
+func init() {
+	proxiedHandlers = map[_type.PacketType]proxiedHandler{
+		_type.PacketHeartBeatResponse: handleProxiedHeartBeatResponse,
+		_type.PacketRegisterPlayer:    handleProxiedRegisterPlayer,
+		_type.PacketChatReceive:       handleProxiedChat,
+	}
+	lobbyHandlers = map[_type.PacketType]lobbyHandler{
+		_type.PacketHeartBeatResponse: handleLobbyHeartBeatResponse,
+		_type.PacketPreregisterInfo:   handleLobbyPreregisterInfo,
+		_type.PacketRegisterPlayer:    handleLobbyRegisterPlayer,
+		_type.PacketQuestionResponse:  handleLobbyQuestionResponse,
+	}
+	downstreamHandlers = map[_type.PacketType]downstreamHandler{
+		_type.PacketHeartBeat:  handleDownstreamHeartBeat,
+		_type.PacketServerInfo: handleDownstreamServerInfo,
+		_type.PacketTeamList:   handleDownstreamTeamList,
+	}
+}
+
+// 目标服务器的心跳已由 ShadowPlayer 代为应答，客户端的 109 只用于计算 RTT，不再转发
+func handleProxiedHeartBeatResponse(connData *ConnectionData, proxy *ProxyConnection, packet _type.Packet) {
+	connData.observeHeartBeatResponse(packet)
+}
+
+func handleLobbyHeartBeatResponse(connData *ConnectionData, packet _type.Packet) {
+	connData.observeHeartBeatResponse(packet)
+}
+
+// handleProxiedChat 拦截以 commands.prefix 开头的聊天作为指令，其余聊天原样转发
+func handleProxiedChat(connData *ConnectionData, proxy *ProxyConnection, packet _type.Packet) {
+	msg, err := Analysis_140(packet)
+	if err != nil {
+		proxy.ForwardPacket(packet)
+		return
+	}
+	name, args, ok := parseCommand(msg)
+	if !ok {
+		proxy.ForwardPacket(packet)
+		return
+	}
+	runCommand(connData, name, args)
+}
+
+func handleProxiedRegisterPlayer(connData *ConnectionData, proxy *ProxyConnection, packet _type.Packet) {
+	packet110, err := Analysis_110(packet)
+	if err != nil {
+		connData.Logger().Warn("解析数据包失败，原样转发", "type", packet.Type, "err", err)
+		proxy.ForwardPacket(packet)
+		return
+	}
+	oldHex := packet110.PlayerHex
+	hash := sha256.Sum256([]byte(packet110.Name))
+	newHex := strings.ToUpper(fmt.Sprintf("%x", hash))
+	packet110.PlayerHex = newHex
+	connData.Logger().Info("改写 PlayerHex", "type", packet.Type, "name", packet110.Name, "old", oldHex, "new", newHex)
+
+	connData.mu.Lock()
+	connData.OldPlayerHex = oldHex
+	connData.NewPlayerHex = newHex
+	connData.mu.Unlock()
+
+	modifiedPacket := Creat_110(packet110)
+	proxy.ForwardPacket(modifiedPacket)
+}
+
+func handleLobbyPreregisterInfo(connData *ConnectionData, packet _type.Packet) {
+	packetData, err := Analysis_160(packet)
+	if err != nil {
+		return
+	}
+	if len(packetData.PlayerName) == 0 {
+		return
+	}
+
+	connData.mu.Lock()
+	connData.packet160 = &_type.Packet{
+		Type:  packet.Type,
+		Bytes: make([]byte, len(packet.Bytes)),
+	}
+	copy(connData.packet160.Bytes, packet.Bytes)
+	connData.PlayerName = packetData.PlayerName
+	connData.mu.Unlock()
+
+	// 同名玩家重新连接时顶替旧会话
+	if oldConn := registry.bindName(packetData.PlayerName, connData); oldConn != nil {
+		oldConn.mu.Lock()
+		if oldConn.proxy != nil {
+			oldConn.proxy.Close()
+			oldConn.proxy = nil
+		}
+		oldConn.mu.Unlock()
+		oldConn.Conn.Close()
+	}
+	if connData.syncCapture() {
+		connData.record(capture.ClientToProxy, packet)
+	}
+	sendBinaryResponse0(connData, Creat_161())
+}
+
+func handleDownstreamHeartBeat(connData *ConnectionData, packet _type.Packet) _type.Packet {
+	connData.mu.RLock()
+	proxy := connData.proxy
+	connData.mu.RUnlock()
+
+	if proxy == nil || !proxy.IsConnected() {
+		return packet
+	}
+
+	sendTime, err := Analysis_108(packet)
+	if err != nil {
+		connData.Logger().Warn("解析数据包失败", "type", packet.Type, "err", err)
+		return packet
+	}
+
+	// 心跳照常转发给客户端，客户端的应答用于计算客户端一侧的 RTT
+	packet109 := Creat_109(sendTime)
+	if err := proxy.inject(packet109); err != nil {
+		connData.Logger().Warn("发送心跳应答到目标服务器失败", "type", packet109.Type, "err", err)
+	}
+	return packet
+}
+
+func handleDownstreamServerInfo(connData *ConnectionData, packet _type.Packet) _type.Packet {
+	connData.mu.RLock()
+	isFog := connData.IsFog
+	connData.mu.RUnlock()
+
+	if isFog {
+		modifiedPacket, err := Creat_106_ModifyFog(packet, isFog)
+		if err != nil {
+			connData.Logger().Warn("改写数据包失败，原样转发", "type", packet.Type, "err", err)
+		} else {
+			packet = modifiedPacket
+		}
+	}
+
+	connData.mu.Lock()
+	if connData.received106 {
+		connData.mu.Unlock()
+		return packet
+	}
+	connData.received106 = true
+	connData.mu.Unlock()
+
+	clientIP := getClientIPFromConnection(connData.Conn)
+	if clientIP != "" {
+		connData.mu.Lock()
+		connData.ClientIP = clientIP
+		connData.mu.Unlock()
+		connData.Logger().Info("玩家客户端IP", "clientIp", clientIP)
+	}
+
+	connData.mu.RLock()
+	oldHex := connData.OldPlayerHex
+	newHex := connData.NewPlayerHex
+	proxy := connData.proxy
+	connData.mu.RUnlock()
+
+	msg1 := "欢迎使用 ShadowPlayer 代理服务器"
+	if proxy != nil {
+		target := net.JoinHostPort(connData.GetIP(), strconv.Itoa(int(connData.GetPort())))
+		msg1 += fmt.Sprintf("\n目标服务器: %s\n实际连接地址: %s", target, proxy.RemoteAddr())
+	}
+	msg2 := ""
+	if oldHex != "" && newHex != "" {
+		msg2 = fmt.Sprintf("PlayerHex已更新\n原值: %s\n新值: %s", oldHex, newHex)
+	}
+
+	sendBinaryResponse0(connData, Creat_141_System(msg1))
+	if msg2 != "" {
+		sendBinaryResponse0(connData, Creat_141_System(msg2))
+	}
+
+	go func() {
+		traceIP := getClientIPFromTrace()
+		if traceIP != "" {
+			connData.Logger().Info("Trace服务返回IP", "traceIp", traceIP)
+		}
+		msg3 := fmt.Sprintf("网络信息\n客户端IP: %s\n外部IP: %s", clientIP, traceIP)
+		sendBinaryResponse0(connData, Creat_141_System(msg3))
+	}()
+	return packet
+}
+
+func handleDownstreamTeamList(connData *ConnectionData, packet _type.Packet) _type.Packet {
+	modifiedPacket, err := Creat_115_Modify(packet, connData.GetIsFog())
+	if err != nil {
+		connData.Logger().Warn("改写数据包失败，原样转发", "type", packet.Type, "err", err)
+		return packet
+	}
+	return modifiedPacket
+}
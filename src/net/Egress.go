@@ -0,0 +1,152 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"hash/fnv"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// egressPool 按 egress 配置为直接连接目标服务器的出站连接选择源地址，
+// 并统计每个源地址上正在使用的连接数
+type egressPool struct {
+	mu       sync.Mutex
+	config   *data.Config
+	addrs    []netip.Addr // 配置中直接写出的 IP
+	ifaces   []string     // 配置中的网卡名，每次选择时读取其当前地址
+	strategy string
+	next     uint64
+	inUse    map[netip.Addr]int
+}
+
+var egress = egressPool{inUse: make(map[netip.Addr]int)}
+
+// refresh 在配置热重载后重新读取地址列表，调用方需持有 mu
+func (p *egressPool) refresh() {
+	config := data.Get()
+	if p.config == config {
+		return
+	}
+	p.addrs, p.ifaces = nil, nil
+	for _, address := range config.Egress.Addresses {
+		if addr, err := netip.ParseAddr(address); err == nil {
+			p.addrs = append(p.addrs, addr.Unmap())
+		} else {
+			p.ifaces = append(p.ifaces, address)
+		}
+	}
+	p.strategy = config.Egress.Strategy
+	p.config = config
+}
+
+// candidates 返回与目标地址族相同的可用源地址，按配置顺序排列
+func (p *egressPool) candidates(target netip.Addr) []netip.Addr {
+	var result []netip.Addr
+	seen := make(map[netip.Addr]bool)
+	add := func(addr netip.Addr) {
+		if addr.Is4() != target.Is4() || seen[addr] {
+			return
+		}
+		// 链路本地地址无法连接其他网段的目标
+		if addr.IsLinkLocalUnicast() && !target.IsLinkLocalUnicast() {
+			return
+		}
+		seen[addr] = true
+		result = append(result, addr)
+	}
+
+	for _, addr := range p.addrs {
+		add(addr)
+	}
+	for _, name := range p.ifaces {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ifaceAddr := range ifaceAddrs {
+			if ipNet, ok := ifaceAddr.(*net.IPNet); ok {
+				if addr, ok := netip.AddrFromSlice(ipNet.IP); ok {
+					add(addr.Unmap())
+				}
+			}
+		}
+	}
+	return result
+}
+
+// pick 为 player 连接 target 选择源地址。未配置 egress 或没有同一地址族的源地址时
+// 返回无效地址，由系统决定源地址
+func (p *egressPool) pick(player string, target netip.Addr) netip.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.refresh()
+	candidates := p.candidates(target.Unmap())
+	if len(candidates) == 0 {
+		return netip.Addr{}
+	}
+
+	switch p.strategy {
+	case "sticky":
+		h := fnv.New32a()
+		h.Write([]byte(player))
+		return candidates[h.Sum32()%uint32(len(candidates))]
+	case "leastUsed":
+		// 从轮转位置开始找，连接数相同时依次使用各个地址
+		start := int(p.next % uint64(len(candidates)))
+		p.next++
+		best := candidates[start]
+		for i := 1; i < len(candidates); i++ {
+			addr := candidates[(start+i)%len(candidates)]
+			if p.inUse[addr] < p.inUse[best] {
+				best = addr
+			}
+		}
+		return best
+	default:
+		addr := candidates[p.next%uint64(len(candidates))]
+		p.next++
+		return addr
+	}
+}
+
+// acquire 记录一条建立在 addr 上的连接。同时进行的多次连接在建立前不计入，
+// leastUsed 在瞬时涌入大量连接时可能不完全均匀
+func (p *egressPool) acquire(addr netip.Addr) {
+	p.mu.Lock()
+	p.inUse[addr]++
+	p.mu.Unlock()
+}
+
+func (p *egressPool) release(addr netip.Addr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.inUse[addr] <= 1 {
+		delete(p.inUse, addr)
+		return
+	}
+	p.inUse[addr]--
+}
+
+// egressDialer 返回绑定了 player 连接 target 时所用源地址的 dialer
+func egressDialer(dialer *net.Dialer, player string, target netip.Addr) *net.Dialer {
+	local := egress.pick(player, target)
+	if !local.IsValid() {
+		return dialer
+	}
+	bound := *dialer
+	bound.LocalAddr = &net.TCPAddr{IP: local.AsSlice()}
+	return &bound
+}
+
+// localAddr 返回连接的本机地址，无法识别时返回无效地址
+func localAddr(conn net.Conn) netip.Addr {
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return addr.AddrPort().Addr().Unmap()
+	}
+	return netip.Addr{}
+}
@@ -0,0 +1,50 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	_type "ShadowPlayer/src/type"
+	"time"
+)
+
+// waitForSpace 在队列超出预算时阻塞读取客户端的协程，直到包入队、代理关闭，
+// 或等待超过 forward.stallTimeout 且策略为 disconnect 时断开会话
+func (pc *ProxyConnection) waitForSpace(packet _type.Packet) {
+	started := time.Now()
+	stats := &pc.connData.stats
+	stats.stalls.Add(1)
+	stats.stalledSince.Store(started.UnixNano())
+	forwardStalls.Inc()
+	pc.logger.Debug("目标服务器读取缓慢，暂停读取客户端", "type", packet.Type, "queued", pc.writer.queuedBytes())
+	defer func() {
+		stalled := time.Since(started)
+		stats.stalledSince.Store(0)
+		stats.stallTime.Add(int64(stalled))
+		forwardStallDuration.Observe(stalled.Seconds())
+	}()
+
+	timer := time.NewTimer(data.Get().Forward.StallTimeout.Std())
+	defer timer.Stop()
+	for {
+		select {
+		case <-pc.closeChan:
+			putBuffer(packet.Bytes)
+			return
+		case <-pc.writer.space:
+		case <-timer.C:
+			config := data.Get().Forward
+			if config.StallPolicy == "disconnect" {
+				forwardStallDisconnects.Inc()
+				pc.logger.Warn("目标服务器长时间未读取数据，断开会话", "stalled", time.Since(started), "queued", pc.writer.queuedBytes())
+				putBuffer(packet.Bytes)
+				pc.connData.disconnect("目标服务器长时间没有接收数据，为避免对局不同步已断开连接")
+				return
+			}
+			pc.logger.Warn("目标服务器长时间未读取数据，继续等待", "stalled", time.Since(started), "queued", pc.writer.queuedBytes())
+			timer.Reset(config.StallTimeout.Std())
+		}
+		if pc.writer.tryPush(packet, data.Get().Forward.MaxBufferedBytes) {
+			pc.logger.Debug("恢复读取客户端", "stalled", time.Since(started))
+			return
+		}
+	}
+}
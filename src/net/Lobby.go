@@ -0,0 +1,259 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	_type "ShadowPlayer/src/type"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+func welcomeMessage(connData *ConnectionData, playerName string) string {
+	config := data.Get()
+	var sb strings.Builder
+	sb.WriteString("欢迎使用 ShadowPlayer 代理服务器\n\n")
+	sb.WriteString(savedOptions(connData, playerName))
+
+	if len(config.Targets) > 0 {
+		sb.WriteString("请输入编号选择要代理的游戏服务器：\n")
+		sb.WriteString(targetMenu(config.Targets))
+		if config.AllowCustomTarget {
+			fmt.Fprintf(&sb, "\n也可以直接输入服务器地址\n   格式：地址:端口 或 地址（默认端口%d）\n", config.DefaultTargetPort)
+		}
+	} else {
+		fmt.Fprintf(&sb, `使用说明：
+1. 请输入需要代理的游戏服务器IP地址
+   格式：地址:端口 或 地址（默认端口%d）
+   地址可以是 IPv4、域名或 IPv6（带端口时写作 [IPv6]:端口）
+   例如：1.2.3.4:%d 或 game.example.com
+
+2. 然后选择是否需要去雾功能
+   输入 y/yes 启用去雾，输入其他内容禁用
+`, config.DefaultTargetPort, config.DefaultTargetPort)
+	}
+
+	sb.WriteString("\n© RELAY-CN Team")
+	return sb.String()
+}
+
+func targetMenu(targets []data.TargetServer) string {
+	var sb strings.Builder
+	for i, target := range targets {
+		fmt.Fprintf(&sb, "%d. %s", i+1, target.Name)
+		if target.Description != "" {
+			fmt.Fprintf(&sb, " - %s", target.Description)
+		}
+		if target.Fog {
+			sb.WriteString(" [去雾]")
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// selectCatalogTarget 将玩家输入的编号解析为目录中的服务器
+func selectCatalogTarget(userInput string) (data.TargetServer, bool) {
+	targets := data.Get().Targets
+	index, err := strconv.Atoi(strings.TrimSpace(userInput))
+	if err != nil || index < 1 || index > len(targets) {
+		return data.TargetServer{}, false
+	}
+	return targets[index-1], true
+}
+
+func handleLobbyRegisterPlayer(connData *ConnectionData, packet _type.Packet) {
+	playerName := findPlayerNameByConnData(connData)
+	sendBinaryResponse0(connData, Creat_117(welcomeMessage(connData, playerName)))
+}
+
+// returnToLobby 断开目标服务器并重新显示欢迎对话框，客户端连接保持不变
+func returnToLobby(connData *ConnectionData) {
+	connData.mu.Lock()
+	proxy := connData.proxy
+	connData.proxy = nil
+	connData.IP = ""
+	connData.Port = 0
+	connData.received106 = false
+	connData.lostNotice = ""
+	connData.mu.Unlock()
+
+	if proxy != nil {
+		proxy.Close()
+	}
+	connData.Logger().Info("玩家返回大厅")
+	playerName := findPlayerNameByConnData(connData)
+	sendBinaryResponse0(connData, Creat_117(welcomeMessage(connData, playerName)))
+}
+
+// targetLost 在代理意外结束后让玩家留在大厅，并询问下一步。
+// 代理已被主动摘除时（回到大厅、踢出、顶替或关闭服务器）不做任何事
+func (cd *ConnectionData) targetLost(proxy *ProxyConnection, reason string) {
+	cd.mu.Lock()
+	if cd.proxy != proxy {
+		cd.mu.Unlock()
+		return
+	}
+	cd.proxy = nil
+	cd.received106 = false
+	cd.mu.Unlock()
+
+	cd.Logger().Info("与目标服务器断开，玩家回到大厅", "reason", reason)
+	cd.offerReconnect("与目标服务器的连接已断开", reason)
+}
+
+// offerReconnect 说明无法继续代理的原因，玩家可以重新连接同一个目标、选择其他服务器或退出
+func (cd *ConnectionData) offerReconnect(title string, reason string) {
+	var sb strings.Builder
+	sb.WriteString(title + "\n\n")
+	fmt.Fprintf(&sb, "目标服务器：%s\n", formatTarget(cd.GetIP(), cd.GetPort(), cd.GetIsFog()))
+	if reason != "" {
+		fmt.Fprintf(&sb, "原因：%s\n", strings.TrimSpace(reason))
+	}
+	notice := sb.String()
+
+	cd.mu.Lock()
+	cd.lostNotice = notice
+	cd.mu.Unlock()
+	sendBinaryResponse0(cd, Creat_117(reconnectMessage(notice, "")))
+}
+
+func reconnectMessage(notice string, hint string) string {
+	var sb strings.Builder
+	if hint != "" {
+		sb.WriteString(hint + "\n\n")
+	}
+	sb.WriteString(notice)
+	sb.WriteString(`
+输入 r 重新连接该服务器
+输入 m 选择其他服务器
+输入 q 退出`)
+	return sb.String()
+}
+
+// handleReconnectInput 处理断开对话框中的输入
+func handleReconnectInput(connData *ConnectionData, playerName string, notice string, userInput string) {
+	switch strings.ToLower(strings.TrimSpace(userInput)) {
+	case "r":
+		connData.mu.Lock()
+		connData.lostNotice = ""
+		connData.mu.Unlock()
+		connData.Logger().Info("玩家重新连接目标服务器")
+		startProxyFromLobby(connData, playerName)
+	case "m":
+		returnToLobby(connData)
+	case "q":
+		connData.Logger().Info("玩家选择退出")
+		connData.disconnect("感谢使用 ShadowPlayer")
+	default:
+		sendBinaryResponse0(connData, Creat_117(reconnectMessage(notice, "输入无效，请输入 r、m 或 q")))
+	}
+}
+
+func handleLobbyQuestionResponse(connData *ConnectionData, packet _type.Packet) {
+	userInput, err := Analysis_118(packet)
+	if err != nil {
+		connData.Logger().Warn("解析用户输入失败", "err", err)
+		return
+	}
+
+	playerName := findPlayerNameByConnData(connData)
+	if playerName == "" {
+		return
+	}
+
+	if connData.cancelDial(errDialCanceled) {
+		return
+	}
+
+	connData.mu.RLock()
+	lostNotice := connData.lostNotice
+	connData.mu.RUnlock()
+	if lostNotice != "" {
+		handleReconnectInput(connData, playerName, lostNotice, userInput)
+		return
+	}
+
+	currentIP := connData.GetIP()
+	currentPort := connData.GetPort()
+
+	if currentIP == "" && currentPort == 0 {
+		handleTargetInput(connData, playerName, userInput)
+		return
+	}
+
+	userInputLower := strings.ToLower(strings.TrimSpace(userInput))
+	isFog := userInputLower == "y" || userInputLower == "yes"
+	connData.SetIsFog(isFog)
+	connData.Logger().Info("玩家设置去雾", "fog", isFog)
+
+	startProxyFromLobby(connData, playerName)
+}
+
+func handleTargetInput(connData *ConnectionData, playerName string, userInput string) {
+	config := data.Get()
+
+	if handleSavedOption(connData, playerName, userInput) {
+		return
+	}
+
+	if target, ok := selectCatalogTarget(userInput); ok {
+		connData.SetIP(target.Host)
+		connData.SetPort(target.Port)
+		connData.SetIsFog(target.Fog)
+		connData.Logger().Info("玩家选择目录中的服务器", "name", target.Name, "fog", target.Fog)
+		startProxyFromLobby(connData, playerName)
+		return
+	}
+
+	if !config.AllowCustomTarget {
+		sendBinaryResponse0(connData, Creat_117(
+			"编号无效，请输入列表中的编号：\n\n"+targetMenu(config.Targets)))
+		return
+	}
+
+	ip, port := parseIPAndPort(userInput)
+	if ip == "" {
+		sendBinaryResponse0(connData, Creat_117(fmt.Sprintf(
+			`服务器地址格式无效，请重新输入
+
+正确格式：
+地址:端口（例如：1.2.3.4:%d 或 [2001:db8::1]:%d）
+或仅输入地址（默认端口%d，例如：game.example.com）
+
+请重新输入服务器地址：`, config.DefaultTargetPort, config.DefaultTargetPort, config.DefaultTargetPort)))
+		return
+	}
+
+	connData.SetIP(ip)
+	connData.SetPort(port)
+	connData.Logger().Info("玩家设置目标服务器")
+	sendBinaryResponse0(connData, Creat_117(fmt.Sprintf(
+		`服务器地址设置成功
+
+目标服务器：%s
+
+是否需要启用去雾功能？
+输入 y 或 yes 启用去雾
+输入其他内容（如 n、no）禁用去雾%s`, net.JoinHostPort(ip, strconv.Itoa(int(port))), lastFogHint(connData, playerName))))
+}
+
+// describeDialFailure 列出已尝试的地址，原因由 classifyDialError 说明
+func describeDialFailure(err error) string {
+	var dialErr *DialError
+	if errors.As(err, &dialErr) {
+		var sb strings.Builder
+		sb.WriteString("已尝试的地址：\n")
+		for _, attempt := range dialErr.Attempts {
+			var upstreamErr *UpstreamError
+			if errors.As(attempt.Err, &upstreamErr) {
+				fmt.Fprintf(&sb, "%s 经上游代理 %s %s失败\n", attempt.Addr, upstreamErr.Proxy, upstreamErr.Stage)
+				continue
+			}
+			fmt.Fprintf(&sb, "%s 连接失败\n", attempt.Addr)
+		}
+		return sb.String()
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package net
+
+import (
+	"ShadowPlayer/src/metrics"
+	_type "ShadowPlayer/src/type"
+	"time"
+)
+
+const (
+	directionUpstream   = "upstream"
+	directionDownstream = "downstream"
+)
+
+var (
+	sessionsActive      = metrics.NewGauge("shadowplayer_sessions_active", "当前客户端会话数")
+	sessionsTotal       = metrics.NewCounter("shadowplayer_sessions_total", "累计接受的客户端会话数")
+	connectionsRejected = metrics.NewCounter("shadowplayer_connections_rejected_total", "因达到 maxConnections 被拒绝的连接数")
+	proxyProtocolErrors = metrics.NewCounter("shadowplayer_proxy_protocol_errors_total", "因 PROXY protocol 头部无效或超时被关闭的连接数")
+
+	tunnelsActive           = metrics.NewGauge("shadowplayer_tunnels_active", "当前连接的 shadowplayer client 隧道数")
+	tunnelHandshakeFailures = metrics.NewCounter("shadowplayer_tunnel_handshake_failures_total", "TLS 握手或客户端证书校验失败的隧道连接数")
+
+	targetDials        = metrics.NewCounterVec("shadowplayer_target_dials_total", "连接目标服务器的次数", "result")
+	targetDialFailures = metrics.NewCounterVec("shadowplayer_target_dial_failures_total", "按原因 (refused、timeout、dns、upstream、error) 统计的连接失败次数", "reason")
+	targetDialDuration = metrics.NewHistogram("shadowplayer_target_dial_duration_seconds", "成功连接目标服务器（含解析）的耗时", metrics.DefaultLatencyBuckets)
+
+	packetsTotal = metrics.NewCounterVec("shadowplayer_packets_total", "按类型和方向统计的数据包数", "type", "direction")
+	packetBytes  = metrics.NewCounterVec("shadowplayer_packet_bytes_total", "按类型和方向统计的字节数（含 8 字节包头）", "type", "direction")
+
+	forwardQueuedBytes      = metrics.NewGauge("shadowplayer_forward_queued_bytes", "所有会话等待发往目标服务器的字节数")
+	forwardStalls           = metrics.NewCounter("shadowplayer_forward_stalls_total", "因目标服务器读取缓慢而暂停读取客户端的次数")
+	forwardStallDuration    = metrics.NewHistogram("shadowplayer_forward_stall_duration_seconds", "每次暂停读取客户端的时长", []float64{0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60})
+	forwardStallDisconnects = metrics.NewCounter("shadowplayer_forward_stall_disconnects_total", "因暂停超过 stallTimeout 而断开的会话数")
+	clientQueuedBytes       = metrics.NewGauge("shadowplayer_client_queued_bytes", "所有会话等待发往客户端的字节数（不含注入的系统消息）")
+
+	clientRTT   = metrics.NewHistogram("shadowplayer_client_rtt_seconds", "客户端应答心跳的往返时间", metrics.DefaultLatencyBuckets)
+	upstreamRTT = metrics.NewHistogram("shadowplayer_upstream_rtt_seconds", "到目标服务器连接的往返时间", metrics.DefaultLatencyBuckets)
+
+	parseErrors = metrics.NewCounterVec("shadowplayer_packet_parse_errors_total", "解析 (parse) 或改写 (modify) 数据包失败的次数", "type", "stage")
+)
+
+// registerServerMetrics 导出连接信号量的占用情况
+func registerServerMetrics(s *Server) {
+	metrics.NewGaugeFunc("shadowplayer_connection_slots_used", "已占用的连接槽位", func() float64 {
+		return float64(len(s.connSemaphore))
+	})
+	metrics.NewGaugeFunc("shadowplayer_connection_slots_capacity", "连接槽位总数 (maxConnections)", func() float64 {
+		return float64(cap(s.connSemaphore))
+	})
+}
+
+// packetTypeLabel 只为已注册的包类型生成独立标签，避免客户端用任意类型号撑大指标
+func packetTypeLabel(packetType _type.PacketType) string {
+	if info, ok := _type.Lookup(packetType); ok {
+		return info.Name
+	}
+	return "UNKNOWN"
+}
+
+func observePacket(packet _type.Packet, direction string) {
+	label := packetTypeLabel(packet.Type)
+	packetsTotal.WithLabelValues(label, direction).Inc()
+	packetBytes.WithLabelValues(label, direction).Add(uint64(8 + len(packet.Bytes)))
+}
+
+// observeDial 记录一次连接目标的结果，被策略拒绝或被取消的连接不算作失败
+func observeDial(started time.Time, err error) {
+	if err != nil {
+		switch failure := classifyDialError(err); failure.kind {
+		case "policy":
+			targetDials.WithLabelValues("rejected").Inc()
+		case "canceled":
+			targetDials.WithLabelValues("canceled").Inc()
+		default:
+			targetDials.WithLabelValues("failure").Inc()
+			targetDialFailures.WithLabelValues(failure.kind).Inc()
+		}
+		return
+	}
+	targetDials.WithLabelValues("success").Inc()
+	targetDialDuration.Observe(time.Since(started).Seconds())
+}
+
+func observeParseError(packetType _type.PacketType, stage string) {
+	parseErrors.WithLabelValues(packetTypeLabel(packetType), stage).Inc()
+}
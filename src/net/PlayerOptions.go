@@ -0,0 +1,249 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxPINFailures   = 5
+	pinFailureWindow = 15 * time.Minute
+)
+
+// pinFailures 按客户端IP统计输错的 PIN（玩家名已有 PIN 记录，输入的 PIN 却没有对应记录），达到上限后在窗口内拒绝 pin 指令，防止逐个猜测他人的 PIN
+var pinFailures = struct {
+	mu   sync.Mutex
+	byIP map[string]*pinFailure
+}{byIP: make(map[string]*pinFailure)}
+
+type pinFailure struct {
+	count int
+	since time.Time
+}
+
+func pinLocked(clientIP string) bool {
+	pinFailures.mu.Lock()
+	defer pinFailures.mu.Unlock()
+	failure, ok := pinFailures.byIP[clientIP]
+	if !ok {
+		return false
+	}
+	if time.Since(failure.since) > pinFailureWindow {
+		delete(pinFailures.byIP, clientIP)
+		return false
+	}
+	return failure.count >= maxPINFailures
+}
+
+// recordPINFailure 记一次失败，返回该IP是否因此被锁定
+func recordPINFailure(clientIP string) bool {
+	pinFailures.mu.Lock()
+	defer pinFailures.mu.Unlock()
+	now := time.Now()
+	for ip, failure := range pinFailures.byIP {
+		if now.Sub(failure.since) > pinFailureWindow {
+			delete(pinFailures.byIP, ip)
+		}
+	}
+	failure, ok := pinFailures.byIP[clientIP]
+	if !ok {
+		failure = &pinFailure{since: now}
+		pinFailures.byIP[clientIP] = failure
+	}
+	failure.count++
+	return failure.count == maxPINFailures
+}
+
+func playerStoreKey(connData *ConnectionData, playerName string) string {
+	connData.mu.RLock()
+	storeKey := connData.storeKey
+	connData.mu.RUnlock()
+	if storeKey != "" {
+		return storeKey
+	}
+	return data.PlayerKey(playerName, getClientIPFromConnection(connData.Conn))
+}
+
+func loadPlayerRecord(connData *ConnectionData, playerName string) (data.PlayerRecord, bool) {
+	store := data.Players()
+	if store == nil || playerName == "" {
+		return data.PlayerRecord{}, false
+	}
+	return store.Get(playerStoreKey(connData, playerName))
+}
+
+func formatTarget(host string, port int32, fog bool) string {
+	result := net.JoinHostPort(host, strconv.Itoa(int(port)))
+	if fog {
+		result += " [去雾]"
+	}
+	return result
+}
+
+// savedOptions 生成欢迎对话框中的快捷选项，未启用玩家存储时返回空字符串
+func savedOptions(connData *ConnectionData, playerName string) string {
+	if data.Players() == nil || playerName == "" {
+		return ""
+	}
+
+	var sb strings.Builder
+	record, ok := loadPlayerRecord(connData, playerName)
+	if ok {
+		sb.WriteString("快捷选项：\n")
+		if last, ok := record.Last(); ok {
+			fmt.Fprintf(&sb, "r. 重新连接上次的服务器 %s\n", formatTarget(last.Host, last.Port, last.Fog))
+		}
+		for i, bookmark := range record.Bookmarks {
+			fmt.Fprintf(&sb, "b%d. %s - %s\n", i+1, bookmark.Name, formatTarget(bookmark.Host, bookmark.Port, bookmark.Fog))
+		}
+		sb.WriteString("bm 名称. 将上次的服务器保存为书签\n")
+		sb.WriteString("del. 删除我的全部记录\n")
+	}
+	sb.WriteString("pin 数字. 使用 6-12 位数字的 PIN 找回记录（更换网络后使用）\n\n")
+	return sb.String()
+}
+
+func lastFogHint(connData *ConnectionData, playerName string) string {
+	record, ok := loadPlayerRecord(connData, playerName)
+	if !ok {
+		return ""
+	}
+	if record.Fog {
+		return "\n（上次选择：启用去雾）"
+	}
+	return "\n（上次选择：禁用去雾）"
+}
+
+// handleSavedOption 处理 r、b<N>、bm、del、pin 等快捷指令，返回 true 表示输入已被处理
+func handleSavedOption(connData *ConnectionData, playerName string, userInput string) bool {
+	store := data.Players()
+	if store == nil {
+		return false
+	}
+
+	input := strings.TrimSpace(userInput)
+	command, arg, _ := strings.Cut(input, " ")
+	command = strings.ToLower(command)
+	arg = strings.TrimSpace(arg)
+
+	switch {
+	case command == "r" && arg == "":
+		record, _ := loadPlayerRecord(connData, playerName)
+		last, ok := record.Last()
+		if !ok {
+			sendBinaryResponse0(connData, Creat_117("没有上次连接的记录\n\n"+welcomeMessage(connData, playerName)))
+			return true
+		}
+		connectSaved(connData, playerName, last.Host, last.Port, last.Fog)
+		return true
+
+	case len(command) > 1 && command[0] == 'b' && arg == "":
+		index, err := strconv.Atoi(command[1:])
+		if err != nil {
+			return false
+		}
+		record, _ := loadPlayerRecord(connData, playerName)
+		if index < 1 || index > len(record.Bookmarks) {
+			sendBinaryResponse0(connData, Creat_117("书签编号无效\n\n"+welcomeMessage(connData, playerName)))
+			return true
+		}
+		bookmark := record.Bookmarks[index-1]
+		connectSaved(connData, playerName, bookmark.Host, bookmark.Port, bookmark.Fog)
+		return true
+
+	case command == "bm":
+		record, _ := loadPlayerRecord(connData, playerName)
+		last, ok := record.Last()
+		var msg string
+		switch {
+		case !ok:
+			msg = "没有上次连接的记录，无法保存书签"
+		case arg == "":
+			msg = "请在 bm 后输入书签名称，例如：bm 常用服"
+		default:
+			bookmark := data.PlayerBookmark{Name: arg, Host: last.Host, Port: last.Port, Fog: last.Fog}
+			if err := store.AddBookmark(playerStoreKey(connData, playerName), bookmark); err != nil {
+				msg = "保存书签失败：" + err.Error()
+			} else {
+				msg = "已保存书签 " + arg
+				markPINOwner(connData, playerName)
+				connData.Logger().Info("玩家保存书签", "bookmark", arg, "saved", formatTarget(last.Host, last.Port, last.Fog))
+			}
+		}
+		sendBinaryResponse0(connData, Creat_117(msg+"\n\n"+welcomeMessage(connData, playerName)))
+		return true
+
+	case command == "del" && arg == "":
+		msg := "已删除你的全部记录"
+		if err := store.Delete(playerStoreKey(connData, playerName)); err != nil {
+			msg = "删除记录失败：" + err.Error()
+		} else {
+			connData.Logger().Info("玩家删除了自己的记录")
+		}
+		sendBinaryResponse0(connData, Creat_117(msg+"\n\n"+welcomeMessage(connData, playerName)))
+		return true
+
+	case command == "pin":
+		clientIP := getClientIPFromConnection(connData.Conn)
+		if pinLocked(clientIP) {
+			sendBinaryResponse0(connData, Creat_117(fmt.Sprintf("PIN 尝试次数过多，请 %s 后再试\n\n", pinFailureWindow)+welcomeMessage(connData, playerName)))
+			return true
+		}
+		if len(arg) < 6 || len(arg) > 12 || strings.Trim(arg, "0123456789") != "" {
+			sendBinaryResponse0(connData, Creat_117("PIN 必须是 6-12 位数字\n\n"+welcomeMessage(connData, playerName)))
+			return true
+		}
+		key := data.PlayerPINKey(playerName, arg)
+		if _, ok := store.Get(key); !ok && store.HasPIN(playerName) && recordPINFailure(clientIP) {
+			connData.Logger().Warn("PIN 尝试次数过多，暂时拒绝该地址的 pin 指令", "window", pinFailureWindow)
+		}
+		connData.mu.Lock()
+		connData.storeKey = key
+		connData.mu.Unlock()
+		sendBinaryResponse0(connData, Creat_117("已切换到 PIN 记录\n\n"+welcomeMessage(connData, playerName)))
+		return true
+	}
+	return false
+}
+
+// markPINOwner 在 PIN 记录写入后标记玩家名，之后输入该玩家名没有记录的 PIN 会计入失败次数，
+// 第一次设置 PIN 则不计入
+func markPINOwner(connData *ConnectionData, playerName string) {
+	connData.mu.RLock()
+	usingPIN := connData.storeKey != ""
+	connData.mu.RUnlock()
+	if usingPIN {
+		data.Players().MarkPIN(playerName)
+	}
+}
+
+// catalogAllows 判断目标是否在 targets 目录中，allowCustomTarget 为 false 时记录和书签也只能连接目录中的服务器
+func catalogAllows(config *data.Config, host string, port int32) bool {
+	if config.AllowCustomTarget {
+		return true
+	}
+	for _, target := range config.Targets {
+		if strings.EqualFold(target.Host, host) && target.Port == port {
+			return true
+		}
+	}
+	return false
+}
+
+func connectSaved(connData *ConnectionData, playerName string, host string, port int32, fog bool) {
+	if !catalogAllows(data.Get(), host, port) {
+		connData.Logger().Info("记录中的服务器不在目录中，拒绝连接", "saved", formatTarget(host, port, fog))
+		sendBinaryResponse0(connData, Creat_117("该服务器已不在可选列表中，请输入列表中的编号\n\n"+welcomeMessage(connData, playerName)))
+		return
+	}
+	connData.SetIP(host)
+	connData.SetPort(port)
+	connData.SetIsFog(fog)
+	connData.Logger().Info("玩家使用记录连接", "saved", formatTarget(host, port, fog))
+	startProxyFromLobby(connData, playerName)
+}
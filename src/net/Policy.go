@@ -0,0 +1,146 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+type PolicyError struct {
+	Target string
+	Reason string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("目标 %s 被策略拒绝: %s", e.Target, e.Reason)
+}
+
+type destinationPolicy struct {
+	allow        []netip.Prefix
+	deny         []netip.Prefix
+	minPort      int32
+	maxPort      int32
+	blockedHosts []string
+}
+
+var policyCache struct {
+	mu     sync.Mutex
+	config *data.Config
+	policy *destinationPolicy
+}
+
+// currentPolicy 返回与当前配置对应的策略，配置热重载后自动重新编译
+func currentPolicy() *destinationPolicy {
+	config := data.Get()
+	policyCache.mu.Lock()
+	defer policyCache.mu.Unlock()
+	if policyCache.config != config {
+		policyCache.policy = compilePolicy(config.DestinationPolicy)
+		policyCache.config = config
+	}
+	return policyCache.policy
+}
+
+func compilePolicy(cfg data.DestinationPolicy) *destinationPolicy {
+	policy := &destinationPolicy{
+		minPort: cfg.MinPort,
+		maxPort: cfg.MaxPort,
+	}
+	// 网段已在配置校验时检查过，这里忽略解析错误
+	for _, cidr := range cfg.AllowCIDRs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			policy.allow = append(policy.allow, prefix.Masked())
+		}
+	}
+	for _, cidr := range cfg.DenyCIDRs {
+		if prefix, err := netip.ParsePrefix(cidr); err == nil {
+			policy.deny = append(policy.deny, prefix.Masked())
+		}
+	}
+	for _, host := range cfg.BlockedHosts {
+		policy.blockedHosts = append(policy.blockedHosts, normalizeHost(host))
+	}
+	return policy
+}
+
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// checkTarget 在解析域名之前检查主机名与端口
+func (p *destinationPolicy) checkTarget(host string, port int32) error {
+	target := net.JoinHostPort(host, fmt.Sprint(port))
+	if port < p.minPort || port > p.maxPort {
+		return &PolicyError{Target: target, Reason: fmt.Sprintf("端口不在允许范围 %d-%d 内", p.minPort, p.maxPort)}
+	}
+	name := normalizeHost(host)
+	for _, blocked := range p.blockedHosts {
+		if matchHostPattern(name, blocked) {
+			return &PolicyError{Target: target, Reason: "主机名在禁止列表中"}
+		}
+	}
+	return nil
+}
+
+// matchHostPattern 判断规范化后的主机名是否匹配 pattern，*.example.com 同时匹配 example.com 本身
+func matchHostPattern(name string, pattern string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return name == suffix || strings.HasSuffix(name, "."+suffix)
+	}
+	return name == pattern
+}
+
+func (p *destinationPolicy) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range p.deny {
+		if prefix.Contains(addr) {
+			return &PolicyError{Target: addr.String(), Reason: "地址位于禁止的网段 " + prefix.String()}
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, prefix := range p.allow {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	return &PolicyError{Target: addr.String(), Reason: "地址不在允许的网段内"}
+}
+
+// resolveAllowed 解析目标并返回通过策略检查的地址。调用方必须直接拨号这些地址，
+// 不能再次使用主机名，否则 DNS 重绑定可以绕过检查
+func resolveAllowed(ctx context.Context, host string, port int32) ([]netip.Addr, error) {
+	policy := currentPolicy()
+	if err := policy.checkTarget(host, port); err != nil {
+		return nil, err
+	}
+
+	candidates, err := lookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []netip.Addr
+	var firstErr error
+	for _, addr := range candidates {
+		if err := policy.checkAddr(addr); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		allowed = append(allowed, addr.Unmap())
+	}
+	if len(allowed) == 0 {
+		if firstErr == nil {
+			firstErr = &PolicyError{Target: host, Reason: "没有可用的地址"}
+		}
+		return nil, firstErr
+	}
+	return allowed, nil
+}
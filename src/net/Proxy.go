@@ -1,45 +1,49 @@
 package net
 
 import (
+	"ShadowPlayer/src/capture"
+	"ShadowPlayer/src/data"
 	"ShadowPlayer/src/type"
 	"bufio"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"strconv"
+	"net/netip"
 	"sync"
+	"syscall"
 	"time"
 )
 
-const (
-	proxyReadTimeout  = 30 * time.Second
-	proxyWriteTimeout = 30 * time.Second
-)
-
 type ProxyConnection struct {
-	clientConn  net.Conn
 	targetConn  net.Conn
+	remoteAddr  netip.AddrPort
+	egressAddr  netip.Addr // 直接连接目标时使用的本机源地址，经上游代理连接时无效
 	connData    *ConnectionData
 	playerName  string
+	logger      *slog.Logger
 	isConnected bool
 	mu          sync.RWMutex
 	closeOnce   sync.Once
 	closeChan   chan struct{}
-	packetChan  chan _type.Packet
+	writer      *frameWriter // 发往目标服务器的唯一写协程，连接建立后创建
+	writeErr    error        // 写入目标服务器失败的原因
 }
 
 func NewProxyConnection(connData *ConnectionData, playerName string) *ProxyConnection {
 	return &ProxyConnection{
-		clientConn: connData.Conn,
 		connData:   connData,
 		playerName: playerName,
+		logger:     connData.Logger(),
 		closeChan:  make(chan struct{}),
-		packetChan: make(chan _type.Packet, 100),
 	}
 }
 
-func (pc *ProxyConnection) Start() error {
+// Start 连接目标服务器并开始转发，ctx 取消时放弃正在进行的连接
+func (pc *ProxyConnection) Start(ctx context.Context) (err error) {
 	targetIP := pc.connData.GetIP()
 	targetPort := pc.connData.GetPort()
 
@@ -47,145 +51,242 @@ func (pc *ProxyConnection) Start() error {
 		return io.ErrUnexpectedEOF
 	}
 
-	targetAddr := net.JoinHostPort(targetIP, strconv.Itoa(int(targetPort)))
-	targetConn, err := net.DialTimeout("tcp", targetAddr, 10*time.Second)
+	started := time.Now()
+	defer func() { observeDial(started, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, data.Get().ProxyDialTimeout.Std())
+	defer cancel()
+
+	addrs, err := resolveAllowed(ctx, targetIP, targetPort)
 	if err != nil {
-		log.Printf("玩家 %s 连接目标服务器失败 %s: %v", pc.playerName, targetAddr, err)
+		pc.logger.Warn("目标服务器未通过检查", "err", err)
 		return err
 	}
 
+	// 记下每个地址实际使用的路由，配置在连接期间重载也不会与连接方式不一致
+	var dialer net.Dialer
+	var routesMu sync.Mutex
+	routes := make(map[netip.AddrPort]*upstreamRoute)
+	dialStarted := time.Now()
+	targetConn, remoteAddr, err := dialHappyEyeballs(ctx, addrs, uint16(targetPort),
+		func(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
+			conn, route, err := dialTarget(ctx, &dialer, pc.playerName, targetIP, addr)
+			routesMu.Lock()
+			routes[addr] = route
+			routesMu.Unlock()
+			return conn, err
+		})
+	if err != nil {
+		pc.logger.Warn("连接目标服务器失败", "err", err)
+		return err
+	}
+	routesMu.Lock()
+	route := routes[remoteAddr]
+	routesMu.Unlock()
+
+	writer := newFrameWriter(targetConn, writerHooks{
+		timeout: func() time.Duration { return data.Get().ProxyWriteTimeout.Std() },
+		written: func(packet _type.Packet) { pc.connData.record(capture.ProxyToTarget, packet) },
+		failed: func(err error) {
+			pc.logger.Warn("转发数据到目标服务器失败", "err", err)
+			pc.fail(err)
+		},
+		queued: forwardQueuedBytes,
+	})
+
+	var egressAddr netip.Addr
+	if route == nil {
+		egressAddr = localAddr(targetConn)
+		egress.acquire(egressAddr)
+	}
+
 	pc.mu.Lock()
 	pc.targetConn = targetConn
+	pc.remoteAddr = remoteAddr
+	pc.egressAddr = egressAddr
+	pc.writer = writer
 	pc.isConnected = true
 	pc.mu.Unlock()
 
-	log.Printf("玩家 %s 已连接到目标服务器 %s", pc.playerName, targetAddr)
+	if route != nil {
+		pc.logger.Info("已通过上游代理连接到目标服务器", "remote", remoteAddr.String(), "upstream", route.name, "elapsed", time.Since(started))
+	} else {
+		pc.logger.Info("已连接到目标服务器", "remote", remoteAddr.String(), "egress", egressAddr.String(), "elapsed", time.Since(started))
+	}
+
+	// 换目标后重新统计；内核还没有 RTT 时用握手耗时作为第一个样本
+	pc.connData.rtt.upstream.reset()
+	if rtt, ok := tcpRTT(targetConn); ok {
+		pc.connData.observeUpstreamRTT(rtt)
+	} else {
+		pc.connData.observeUpstreamRTT(time.Since(dialStarted))
+	}
+	pc.connData.captureTarget(pc)
 
-	go pc.forwardClientToTarget()
 	go pc.forwardTargetToClient()
 
 	return nil
 }
 
-func (pc *ProxyConnection) forwardClientToTarget() {
-	defer pc.Close()
-
-	for {
-		select {
-		case <-pc.closeChan:
-			return
-		case packet := <-pc.packetChan:
-			pc.mu.RLock()
-			targetConn := pc.targetConn
-			isConnected := pc.isConnected
-			pc.mu.RUnlock()
-
-			if !isConnected || targetConn == nil {
-				putBuffer(packet.Bytes)
-				return
-			}
-
-			if err := pc.sendPacketToTarget(targetConn, packet); err != nil {
-				log.Printf("玩家 %s 转发数据到目标服务器失败: %v", pc.playerName, err)
-				putBuffer(packet.Bytes)
-				return
-			}
-
-			putBuffer(packet.Bytes)
-		}
+// ForwardPacket 把包交给发往目标服务器的写协程。排队数据超过 forward.maxBufferedBytes 时阻塞，
+// 直到目标服务器读走数据，不会丢弃数据包
+func (pc *ProxyConnection) ForwardPacket(packet _type.Packet) {
+	select {
+	case <-pc.closeChan:
+		return
+	default:
+	}
+	if pc.writer == nil {
+		return
 	}
-}
 
-func (pc *ProxyConnection) ForwardPacket(packet _type.Packet) {
 	packetCopy := _type.Packet{
 		Type:  packet.Type,
-		Bytes: make([]byte, len(packet.Bytes)),
+		Bytes: getBuffer(int32(len(packet.Bytes))),
 	}
 	copy(packetCopy.Bytes, packet.Bytes)
 
-	select {
-	case <-pc.closeChan:
+	if pc.writer.tryPush(packetCopy, data.Get().Forward.MaxBufferedBytes) {
 		return
-	case pc.packetChan <- packetCopy:
-	default:
-		log.Printf("玩家 %s 数据包通道已满，丢弃数据包", pc.playerName)
 	}
+	pc.waitForSpace(packetCopy)
 }
 
+// forwardTargetToClient 把目标服务器发来的包转给客户端，直到连接结束。
+// 连接意外结束时让玩家回到大厅并说明原因；处理下行包时的 panic 只结束当前代理连接
 func (pc *ProxyConnection) forwardTargetToClient() {
-	defer pc.Close()
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			pc.logger.Error("处理目标服务器数据包时发生 panic", "panic", r)
+			err = errBadFrame
+		}
+		pc.Close()
+		if reason := pc.lossReason(err); reason != "" {
+			pc.connData.targetLost(pc, reason)
+		}
+	}()
+	err = pc.copyTargetToClient()
+}
 
+// copyTargetToClient 返回读取目标服务器时遇到的错误；本地主动关闭或客户端已断开时返回 nil
+func (pc *ProxyConnection) copyTargetToClient() error {
 	pc.mu.RLock()
 	targetConn := pc.targetConn
 	pc.mu.RUnlock()
 
 	if targetConn == nil {
-		return
+		return nil
 	}
 
 	reader := bufio.NewReader(targetConn)
 
 	for {
-		select {
-		case <-pc.closeChan:
-			return
-		default:
+		if pc.isClosed() {
+			return nil
 		}
 
-		targetConn.SetReadDeadline(time.Now().Add(proxyReadTimeout))
+		config := data.Get()
+		targetConn.SetReadDeadline(time.Now().Add(config.ProxyReadTimeout.Std()))
 
 		var msgLen int32
 		if err := binary.Read(reader, binary.BigEndian, &msgLen); err != nil {
-			if err != io.EOF {
-				log.Printf("玩家 %s 从目标服务器读取消息长度错误: %v", pc.playerName, err)
+			switch {
+			case pc.isClosed():
+				// 本地主动关闭，例如玩家回到大厅
+				return nil
+			case err == io.EOF:
+				pc.logger.Info("目标服务器关闭了连接")
+			default:
+				pc.logger.Warn("从目标服务器读取消息长度错误", "err", err)
 			}
-			return
+			return err
 		}
 
-		if msgLen <= 0 || msgLen > maxMessageSize {
-			log.Printf("玩家 %s 从目标服务器收到非法消息长度: %d", pc.playerName, msgLen)
-			return
+		if msgLen <= 0 || msgLen > config.MaxMessageSize {
+			pc.logger.Warn("从目标服务器收到非法消息长度", "length", msgLen)
+			return fmt.Errorf("%w: 消息长度 %d", errBadFrame, msgLen)
 		}
 
 		var msgType int32
 		if err := binary.Read(reader, binary.BigEndian, &msgType); err != nil {
-			log.Printf("玩家 %s 从目标服务器读取消息类型错误: %v", pc.playerName, err)
-			return
+			pc.logger.Warn("从目标服务器读取消息类型错误", "err", err)
+			return err
 		}
 
 		msgData := getBuffer(msgLen)
 		if _, err := io.ReadFull(reader, msgData); err != nil {
-			log.Printf("玩家 %s 从目标服务器读取消息体错误: %v", pc.playerName, err)
+			pc.logger.Warn("从目标服务器读取消息体错误", "type", _type.PacketType(msgType), "err", err)
 			putBuffer(msgData)
-			return
+			return err
 		}
 
 		packet := _type.Packet{
-			Type:  msgType,
+			Type:  _type.PacketType(msgType),
 			Bytes: msgData,
 		}
+		pc.connData.record(capture.TargetToProxy, packet)
 
-		if err := sendBinaryResponse(pc.clientConn, packet); err != nil {
-			log.Printf("玩家 %s 转发数据到客户端失败: %v", pc.playerName, err)
-			putBuffer(msgData)
-			return
+		if err := sendBinaryResponse(pc.connData, packet); err != nil {
+			return nil
 		}
+	}
+}
+
+var errBadFrame = errors.New("目标服务器发送了无法识别的数据")
 
-		putBuffer(msgData)
+// fail 记录写入目标服务器失败的原因并关闭代理
+func (pc *ProxyConnection) fail(err error) {
+	pc.mu.Lock()
+	if pc.writeErr == nil {
+		pc.writeErr = err
 	}
+	pc.mu.Unlock()
+	pc.Close()
 }
 
-func (pc *ProxyConnection) sendPacketToTarget(targetConn net.Conn, packet _type.Packet) error {
-	targetConn.SetWriteDeadline(time.Now().Add(proxyWriteTimeout))
+// lossReason 把连接结束的原因转换为给玩家看的说明，不需要说明时返回空字符串
+func (pc *ProxyConnection) lossReason(readErr error) string {
+	pc.mu.RLock()
+	writeErr := pc.writeErr
+	pc.mu.RUnlock()
 
-	total := 8 + len(packet.Bytes)
-	out := make([]byte, total)
-	binary.BigEndian.PutUint32(out[0:4], uint32(len(packet.Bytes)))
-	binary.BigEndian.PutUint32(out[4:8], uint32(packet.Type))
-	copy(out[8:], packet.Bytes)
+	var netErr net.Error
+	switch {
+	case writeErr != nil:
+		if errors.As(writeErr, &netErr) && netErr.Timeout() {
+			return fmt.Sprintf("目标服务器超过 %s 没有接收数据", data.Get().ProxyWriteTimeout)
+		}
+		return "向目标服务器发送数据失败"
+	case readErr == nil:
+		return ""
+	case errors.Is(readErr, io.EOF), errors.Is(readErr, io.ErrUnexpectedEOF):
+		return "目标服务器关闭了连接"
+	case errors.Is(readErr, syscall.ECONNRESET):
+		return "目标服务器重置了连接"
+	case errors.As(readErr, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("目标服务器超过 %s 没有发送数据", data.Get().ProxyReadTimeout)
+	case errors.Is(readErr, errBadFrame):
+		return errBadFrame.Error()
+	}
+	return "与目标服务器的连接中断"
+}
 
-	_, err := targetConn.Write(out)
-	return err
+// inject 把 ShadowPlayer 自己生成的包放入目标连接的优先队列
+func (pc *ProxyConnection) inject(packet _type.Packet) error {
+	if pc.writer == nil {
+		return errWriterClosed
+	}
+	return pc.writer.inject(packet)
+}
+
+func (pc *ProxyConnection) isClosed() bool {
+	select {
+	case <-pc.closeChan:
+		return true
+	default:
+		return false
+	}
 }
 
 func (pc *ProxyConnection) Close() {
@@ -193,24 +294,47 @@ func (pc *ProxyConnection) Close() {
 		close(pc.closeChan)
 
 		pc.mu.Lock()
+		if pc.writer != nil {
+			pc.writer.close(false)
+		}
 		if pc.targetConn != nil {
 			pc.targetConn.Close()
 			pc.targetConn = nil
 		}
+		egressAddr := pc.egressAddr
 		pc.isConnected = false
 		pc.mu.Unlock()
 
-		log.Printf("玩家 %s 代理连接已关闭", pc.playerName)
+		if egressAddr.IsValid() {
+			egress.release(egressAddr)
+		}
+
+		pc.logger.Info("代理连接已关闭")
 	})
 }
 
+func (pc *ProxyConnection) RemoteAddr() netip.AddrPort {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.remoteAddr
+}
+
+// EgressAddr 返回直接连接目标时使用的本机源地址
+func (pc *ProxyConnection) EgressAddr() netip.Addr {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.egressAddr
+}
+
 func (pc *ProxyConnection) IsConnected() bool {
 	pc.mu.RLock()
 	defer pc.mu.RUnlock()
 	return pc.isConnected
 }
 
-func StartProxyForPlayer(playerName string) error {
+// StartProxyForPlayer 为玩家建立到其目标服务器的代理。ctx 在连接建立后被取消时
+// （例如客户端已断开）关闭新连接并返回 ctx 的错误
+func StartProxyForPlayer(ctx context.Context, playerName string) error {
 	connData, ok := GetConnectionData(playerName)
 	if !ok {
 		return io.ErrUnexpectedEOF
@@ -231,11 +355,16 @@ func StartProxyForPlayer(playerName string) error {
 	connData.mu.Unlock()
 
 	proxy := NewProxyConnection(connData, playerName)
-	if err := proxy.Start(); err != nil {
+	if err := proxy.Start(ctx); err != nil {
 		return err
 	}
 
 	connData.mu.Lock()
+	if err := ctx.Err(); err != nil {
+		connData.mu.Unlock()
+		proxy.Close()
+		return err
+	}
 	connData.proxy = proxy
 	connData.mu.Unlock()
 
@@ -0,0 +1,194 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1 头部（含结尾的 CRLF）最长 107 字节
+const proxyV1MaxLength = 107
+
+var trustedProxyCache struct {
+	mu       sync.Mutex
+	config   *data.Config
+	prefixes []netip.Prefix
+}
+
+// trustedProxySource 判断连接是否来自需要解析 PROXY protocol 头部的负载均衡
+func trustedProxySource(conn net.Conn) bool {
+	config := data.Get()
+	if !config.ProxyProtocol.Enabled {
+		return false
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	trustedProxyCache.mu.Lock()
+	if trustedProxyCache.config != config {
+		trustedProxyCache.prefixes = nil
+		// 网段已在配置校验时检查过，这里忽略解析错误
+		for _, cidr := range config.ProxyProtocol.TrustedCIDRs {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil {
+				trustedProxyCache.prefixes = append(trustedProxyCache.prefixes, prefix.Masked())
+			}
+		}
+		trustedProxyCache.config = config
+	}
+	prefixes := trustedProxyCache.prefixes
+	trustedProxyCache.mu.Unlock()
+
+	source := addr.AddrPort().Addr().Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxiedConn 是经负载均衡转发的连接，RemoteAddr 返回头部中玩家的真实地址
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// NetConn 返回底层连接
+func (c *proxiedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// readProxyHeader 读取 PROXY protocol v1 或 v2 头部。头部表示负载均衡自身的连接（LOCAL、UNKNOWN）
+// 或地址族不是 TCP over IPv4/IPv6 时保留原来的地址
+func readProxyHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReaderSize(conn, 256)
+	signature, err := reader.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("读取 PROXY protocol 头部失败: %w", err)
+	}
+
+	var remote net.Addr
+	switch {
+	case bytes.Equal(signature, proxyV1Prefix):
+		remote, err = readProxyV1(reader)
+	case bytes.Equal(signature, proxyV2Signature[:len(proxyV1Prefix)]):
+		remote, err = readProxyV2(reader)
+	default:
+		return nil, errors.New("连接没有 PROXY protocol 头部")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapped net.Conn = conn
+	if reader.Buffered() > 0 {
+		wrapped = &bufferedConn{Conn: conn, reader: reader}
+	}
+	if remote == nil {
+		return wrapped, nil
+	}
+	return &proxiedConn{Conn: wrapped, remote: remote}, nil
+}
+
+func readProxyV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("读取 PROXY protocol v1 头部失败: %w", err)
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	header, found := strings.CutSuffix(string(line), "\r\n")
+	if !found {
+		return nil, errors.New("PROXY protocol v1 头部过长")
+	}
+
+	fields := strings.Split(header, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("PROXY protocol v1 头部格式无效: %q", header)
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("PROXY protocol v1 源地址无效: %q", fields[2])
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("PROXY protocol v1 源端口无效: %q", fields[4])
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("读取 PROXY protocol v2 头部失败: %w", err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, errors.New("PROXY protocol v2 签名无效")
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("不支持的 PROXY protocol 版本 %d", header[12]>>4)
+	}
+	command := header[12] & 0x0f
+	family := header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, fmt.Errorf("读取 PROXY protocol v2 地址失败: %w", err)
+	}
+
+	switch command {
+	case 0x0: // LOCAL：负载均衡自身的连接，例如健康检查
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("不支持的 PROXY protocol v2 命令 %d", command)
+	}
+
+	// 地址之后可能还有 TLV，这里不使用
+	var addrLen int
+	switch family {
+	case 0x11: // TCP over IPv4
+		addrLen = 4
+	case 0x21: // TCP over IPv6
+		addrLen = 16
+	default:
+		return nil, nil
+	}
+	if len(payload) < addrLen*2+4 {
+		return nil, errors.New("PROXY protocol v2 地址长度不足")
+	}
+	addr, _ := netip.AddrFromSlice(payload[:addrLen])
+	port := binary.BigEndian.Uint16(payload[addrLen*2:])
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr.Unmap(), port)), nil
+}
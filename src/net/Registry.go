@@ -0,0 +1,127 @@
+package net
+
+import (
+	"net"
+	"sort"
+	"sync"
+)
+
+// sessionRegistry 按会话 ID、客户端连接和玩家名索引所有会话，查找均为 O(1)。
+// 会话在接受连接时登记 ID 与连接，收到 160 包后才绑定玩家名
+type sessionRegistry struct {
+	mu     sync.RWMutex
+	byID   map[string]*ConnectionData
+	byConn map[net.Conn]*ConnectionData
+	byName map[string]*ConnectionData
+	names  map[*ConnectionData]string // 会话当前绑定的玩家名
+}
+
+var registry = newSessionRegistry()
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		byID:   make(map[string]*ConnectionData),
+		byConn: make(map[net.Conn]*ConnectionData),
+		byName: make(map[string]*ConnectionData),
+		names:  make(map[*ConnectionData]string),
+	}
+}
+
+func (r *sessionRegistry) add(connData *ConnectionData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[connData.ID] = connData
+	r.byConn[connData.Conn] = connData
+}
+
+// remove 删除会话的所有索引；玩家名已被新会话占用时不影响新会话
+func (r *sessionRegistry) remove(connData *ConnectionData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byID, connData.ID)
+	delete(r.byConn, connData.Conn)
+	r.unbindLocked(connData)
+}
+
+func (r *sessionRegistry) unbindLocked(connData *ConnectionData) {
+	name, ok := r.names[connData]
+	if !ok {
+		return
+	}
+	delete(r.names, connData)
+	if r.byName[name] == connData {
+		delete(r.byName, name)
+	}
+}
+
+// bindName 把玩家名绑定到会话，返回之前使用该玩家名的其他会话（没有则为 nil）
+func (r *sessionRegistry) bindName(playerName string, connData *ConnectionData) *ConnectionData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.byName[playerName]
+	if previous == connData {
+		return nil
+	}
+	r.unbindLocked(connData)
+	if previous != nil {
+		delete(r.names, previous)
+	}
+	r.byName[playerName] = connData
+	r.names[connData] = playerName
+	return previous
+}
+
+func (r *sessionRegistry) lookupID(id string) *ConnectionData {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byID[id]
+}
+
+func (r *sessionRegistry) lookupConn(conn net.Conn) *ConnectionData {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byConn[conn]
+}
+
+func (r *sessionRegistry) lookupName(playerName string) *ConnectionData {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byName[playerName]
+}
+
+// nameOf 返回会话当前绑定的玩家名，未绑定或已被新会话顶替时为空
+func (r *sessionRegistry) nameOf(connData *ConnectionData) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.names[connData]
+}
+
+// all 返回所有会话的快照，按连接时间排序
+func (r *sessionRegistry) all() []*ConnectionData {
+	r.mu.RLock()
+	result := make([]*ConnectionData, 0, len(r.byID))
+	for _, connData := range r.byID {
+		result = append(result, connData)
+	}
+	r.mu.RUnlock()
+	sortByConnectedAt(result)
+	return result
+}
+
+// players 返回已绑定玩家名的会话快照，按连接时间排序
+func (r *sessionRegistry) players() []*ConnectionData {
+	r.mu.RLock()
+	result := make([]*ConnectionData, 0, len(r.byName))
+	for _, connData := range r.byName {
+		result = append(result, connData)
+	}
+	r.mu.RUnlock()
+	sortByConnectedAt(result)
+	return result
+}
+
+func sortByConnectedAt(sessions []*ConnectionData) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnectedAt.Before(sessions[j].ConnectedAt)
+	})
+}
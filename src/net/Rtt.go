@@ -0,0 +1,214 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	_type "ShadowPlayer/src/type"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rttStats 是一条链路的往返时间统计。平均值为 1/8 权重的 EWMA，
+// 抖动按 RFC 3550 取相邻两次采样之差的平滑值
+type rttStats struct {
+	mu      sync.Mutex
+	samples uint64
+	last    time.Duration
+	ewma    time.Duration
+	jitter  time.Duration
+	min     time.Duration
+	max     time.Duration
+}
+
+func (s *rttStats) add(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.samples == 0 {
+		s.ewma, s.min, s.max = rtt, rtt, rtt
+	} else {
+		diff := rtt - s.last
+		if diff < 0 {
+			diff = -diff
+		}
+		s.jitter += (diff - s.jitter) / 16
+		s.ewma += (rtt - s.ewma) / 8
+		s.min = min(s.min, rtt)
+		s.max = max(s.max, rtt)
+	}
+	s.last = rtt
+	s.samples++
+}
+
+func (s *rttStats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples, s.last, s.ewma, s.jitter, s.min, s.max = 0, 0, 0, 0, 0, 0
+}
+
+// RTTStats 为毫秒表示的 RTT 统计，Samples 为 0 时其余字段无意义
+type RTTStats struct {
+	Samples  uint64  `json:"samples"`
+	LastMs   float64 `json:"lastMs"`
+	AvgMs    float64 `json:"avgMs"`
+	JitterMs float64 `json:"jitterMs"`
+	MinMs    float64 `json:"minMs"`
+	MaxMs    float64 `json:"maxMs"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (s *rttStats) snapshot() RTTStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return RTTStats{
+		Samples:  s.samples,
+		LastMs:   milliseconds(s.last),
+		AvgMs:    milliseconds(s.ewma),
+		JitterMs: milliseconds(s.jitter),
+		MinMs:    milliseconds(s.min),
+		MaxMs:    milliseconds(s.max),
+	}
+}
+
+func (s RTTStats) String() string {
+	if s.Samples == 0 {
+		return "暂无数据"
+	}
+	return fmt.Sprintf("当前 %.1fms 平均 %.1fms 抖动 %.1fms 最小 %.1fms 最大 %.1fms",
+		s.LastMs, s.AvgMs, s.JitterMs, s.MinMs, s.MaxMs)
+}
+
+// RTTInfo 为会话两段链路的 RTT：客户端到 ShadowPlayer、ShadowPlayer 到目标服务器
+type RTTInfo struct {
+	Client   RTTStats `json:"client"`
+	Upstream RTTStats `json:"upstream"`
+}
+
+const (
+	maxPendingPings = 32
+	pingExpiry      = 30 * time.Second
+)
+
+// pingTracker 记录发给客户端、尚未收到 109 应答的心跳。
+// 包括 ShadowPlayer 自己发出的和转发自目标服务器的，以 108 中的时间值为键
+type pingTracker struct {
+	mu      sync.Mutex
+	pending map[int64]time.Time
+}
+
+func (p *pingTracker) sent(sendTime int64) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending == nil {
+		p.pending = make(map[int64]time.Time)
+	}
+	if len(p.pending) >= maxPendingPings {
+		for key, at := range p.pending {
+			if now.Sub(at) > pingExpiry {
+				delete(p.pending, key)
+			}
+		}
+		if len(p.pending) >= maxPendingPings {
+			return
+		}
+	}
+	p.pending[sendTime] = now
+}
+
+// answered 返回对应心跳的往返时间，未知或重复的应答返回 false
+func (p *pingTracker) answered(sendTime int64) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at, ok := p.pending[sendTime]
+	if !ok {
+		return 0, false
+	}
+	delete(p.pending, sendTime)
+	return time.Since(at), true
+}
+
+// sessionRTT 汇总会话的心跳与两段链路的统计
+type sessionRTT struct {
+	pings    pingTracker
+	client   rttStats
+	upstream rttStats
+}
+
+func (cd *ConnectionData) RTT() RTTInfo {
+	return RTTInfo{
+		Client:   cd.rtt.client.snapshot(),
+		Upstream: cd.rtt.upstream.snapshot(),
+	}
+}
+
+// observeHeartBeatSent 在 108 实际写给客户端后开始计时
+func (cd *ConnectionData) observeHeartBeatSent(packet _type.Packet) {
+	if sendTime, err := Analysis_108(packet); err == nil {
+		cd.rtt.pings.sent(sendTime)
+	}
+}
+
+// observeHeartBeatResponse 用客户端的 109 应答计算客户端一侧的 RTT
+func (cd *ConnectionData) observeHeartBeatResponse(packet _type.Packet) {
+	sendTime, err := Analysis_108(packet)
+	if err != nil {
+		return
+	}
+	if rtt, ok := cd.rtt.pings.answered(sendTime); ok {
+		cd.rtt.client.add(rtt)
+		clientRTT.Observe(rtt.Seconds())
+	}
+}
+
+func (cd *ConnectionData) observeUpstreamRTT(rtt time.Duration) {
+	cd.rtt.upstream.add(rtt)
+	upstreamRTT.Observe(rtt.Seconds())
+}
+
+// sampleUpstreamRTT 从内核读取到目标服务器连接的 RTT，不支持的平台上不做任何事
+func (pc *ProxyConnection) sampleUpstreamRTT() {
+	pc.mu.RLock()
+	targetConn := pc.targetConn
+	pc.mu.RUnlock()
+	if targetConn == nil {
+		return
+	}
+	if rtt, ok := tcpRTT(targetConn); ok {
+		pc.connData.observeUpstreamRTT(rtt)
+	}
+}
+
+// pingReport 生成玩家在游戏内查询时看到的延迟信息
+func (cd *ConnectionData) pingReport() string {
+	info := cd.RTT()
+	var sb strings.Builder
+	sb.WriteString("延迟统计\n")
+	fmt.Fprintf(&sb, "你 ↔ ShadowPlayer: %s\n", info.Client)
+	fmt.Fprintf(&sb, "ShadowPlayer ↔ 目标服务器: %s", info.Upstream)
+	return sb.String()
+}
+
+// pingLoop 按 pingInterval 向所有玩家发送心跳，并采样到目标服务器的 RTT，直到服务器关闭
+func (s *Server) pingLoop() {
+	for !s.isClosing() {
+		interval := data.Get().PingInterval.Std()
+		if interval <= 0 {
+			time.Sleep(time.Second)
+			continue
+		}
+		time.Sleep(interval)
+		RefreshPing()
+		for _, connData := range registry.players() {
+			connData.mu.RLock()
+			proxy := connData.proxy
+			connData.mu.RUnlock()
+			if proxy != nil && proxy.IsConnected() {
+				proxy.sampleUpstreamRTT()
+			}
+		}
+	}
+}
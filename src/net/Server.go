@@ -1,49 +1,121 @@
 package net
 
 import (
+	"ShadowPlayer/src/capture"
 	"ShadowPlayer/src/data"
 	"ShadowPlayer/src/http"
+	"ShadowPlayer/src/logging"
+	"ShadowPlayer/src/tunnel"
 	_type "ShadowPlayer/src/type"
 	"bufio"
-	"crypto/sha256"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"os"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-)
 
-const (
-	maxConnections = 1000       // 最大并发连接数
-	maxMessageSize = 512 * 1024 // 单条消息最大0.5MB
-	readTimeout    = 30 * time.Second
-	writeTimeout   = 30 * time.Second
+	"github.com/google/uuid"
 )
 
 type ConnectionData struct {
+	ID           string
 	Conn         net.Conn
+	ConnectedAt  time.Time
+	PlayerName   string
 	IP           string
 	Port         int32
 	IsFog        bool
 	proxy        *ProxyConnection
 	packet160    *_type.Packet
 	received106  bool
+	lostNotice   string       // 无法继续代理时给玩家的说明，玩家在对话框中做出选择前非空
+	dialing      *pendingDial // 正在后台连接目标服务器时非 nil
 	ClientIP     string
+	storeKey     string // 玩家存储中的记录键，设置 PIN 后不再使用默认的 玩家名@IP
 	OldPlayerHex string
 	NewPlayerHex string
+	stats        sessionStats
+	rtt          sessionRTT
+	capture      atomic.Pointer[capture.Session] // 未抓包时为 nil
+	writer       *frameWriter                    // 发往客户端的唯一写协程
+	logger       *slog.Logger
+	logCloser    io.Closer
 	mu           sync.RWMutex
 }
 
 func NewConnectionData(conn net.Conn) *ConnectionData {
-	return &ConnectionData{
-		Conn:  conn,
-		IsFog: false,
+	connData := &ConnectionData{
+		ID:          uuid.NewString(),
+		ConnectedAt: time.Now(),
+		IsFog:       false,
 	}
+	connData.Conn = &statsConn{Conn: conn, stats: &connData.stats}
+	connData.writer = newFrameWriter(connData.Conn, writerHooks{
+		timeout: func() time.Duration { return data.Get().WriteTimeout.Std() },
+		written: func(packet _type.Packet) {
+			connData.stats.downstream.addPacket()
+			observePacket(packet, directionDownstream)
+			connData.record(capture.ProxyToClient, packet)
+			if packet.Type == _type.PacketHeartBeat {
+				connData.observeHeartBeatSent(packet)
+			}
+		},
+		failed: func(err error) {
+			connData.Logger().Warn("发送数据到客户端失败", "err", err)
+			connData.Conn.Close()
+		},
+		queued: clientQueuedBytes,
+	})
+	return connData
+}
+
+// Logger 返回带有会话 ID、客户端地址、玩家名与目标服务器的日志器
+func (cd *ConnectionData) Logger() *slog.Logger {
+	cd.mu.RLock()
+	logger := cd.logger
+	playerName := cd.PlayerName
+	target := ""
+	if cd.IP != "" {
+		target = net.JoinHostPort(cd.IP, strconv.Itoa(int(cd.Port)))
+	}
+	cd.mu.RUnlock()
+
+	if logger == nil {
+		logger = slog.Default().With("session", cd.ID)
+	}
+	return logger.With("player", playerName, "target", target)
+}
+
+func (cd *ConnectionData) openLog() {
+	logger, closer := logging.OpenSession(cd.ID)
+	cd.mu.Lock()
+	cd.logger = logger.With("client", cd.Conn.RemoteAddr().String())
+	cd.logCloser = closer
+	cd.mu.Unlock()
+}
+
+func (cd *ConnectionData) closeLog() {
+	cd.mu.Lock()
+	closer := cd.logCloser
+	cd.logCloser = nil
+	cd.mu.Unlock()
+	if closer != nil {
+		closer.Close()
+	}
+}
+
+func (cd *ConnectionData) GetPlayerName() string {
+	cd.mu.RLock()
+	defer cd.mu.RUnlock()
+	return cd.PlayerName
 }
 
 func (cd *ConnectionData) GetIP() string {
@@ -82,62 +154,222 @@ func (cd *ConnectionData) SetIsFog(isFog bool) {
 	cd.IsFog = isFog
 }
 
-var (
-	connSemaphore     = make(chan struct{}, maxConnections)
-	activeConnections sync.Map
-)
+var ErrServerClosed = errors.New("服务器已关闭")
+
+type Server struct {
+	listeners     []net.Listener // 游戏端口以及隧道等其他入口，关闭服务器时一并关闭
+	tunnels       map[*tunnel.Session]struct{}
+	connSemaphore chan struct{}
+	mu            sync.Mutex
+	closing       bool
+	wg            sync.WaitGroup
+}
 
-func Start() {
-	listener, err := net.Listen("tcp", ":"+strconv.Itoa(int(data.GlobalConfig.Port)))
+func NewServer() *Server {
+	s := &Server{
+		connSemaphore: make(chan struct{}, data.Get().MaxConnections),
+		tunnels:       make(map[*tunnel.Session]struct{}),
+	}
+	registerServerMetrics(s)
+	return s
+}
+
+func (s *Server) ListenAndServe() error {
+	config := data.Get()
+	listener, err := net.Listen("tcp", net.JoinHostPort(config.ListenAddress, strconv.Itoa(int(config.Port))))
 	if err != nil {
-		log.Printf("端口 %d 已被占用: %v", data.GlobalConfig.Port, err)
-		fmt.Println("\n端口被占用，请检查配置或关闭占用该端口的程序")
-		fmt.Println("按回车键退出...")
-		bufio.NewReader(os.Stdin).ReadBytes('\n')
-		os.Exit(1)
+		return err
+	}
+
+	if !s.addListener(listener) {
+		return ErrServerClosed
 	}
 	defer listener.Close()
 
-	log.Println("服务器启动，监听端口:", data.GlobalConfig.Port)
+	slog.Info("服务器启动", "listen", listener.Addr().String())
+	go s.pingLoop()
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			log.Printf("接受连接错误: %v", err)
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			slog.Warn("接受连接错误", "err", err)
 			continue
 		}
 
 		select {
-		case connSemaphore <- struct{}{}:
-			go func(c net.Conn) {
-				connData := NewConnectionData(c)
-				defer func() {
-					connData.mu.Lock()
-					if connData.proxy != nil {
-						connData.proxy.Close()
-						connData.proxy = nil
-					}
-					connData.mu.Unlock()
-
-					activeConnections.Range(func(key, value interface{}) bool {
-						if value == connData {
-							storedKey, ok := key.(string)
-							if ok {
-								activeConnections.Delete(storedKey)
-							}
-							return false
-						}
-						return true
-					})
-					c.Close()
-					<-connSemaphore
-				}()
-				handleBinaryConnection(connData)
-			}(conn)
+		case s.connSemaphore <- struct{}{}:
+			if trustedProxySource(conn) {
+				go s.acceptProxied(conn)
+				continue
+			}
+			s.startSession(conn)
 		default:
 			conn.Close()
-			log.Println("连接数已达上限，拒绝新连接")
+			connectionsRejected.Inc()
+			slog.Warn("连接数已达上限，拒绝新连接", "client", conn.RemoteAddr().String())
+		}
+	}
+}
+
+// addListener 登记一个入口，服务器正在关闭时关闭它并返回 false
+func (s *Server) addListener(listener net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		listener.Close()
+		return false
+	}
+	s.listeners = append(s.listeners, listener)
+	return true
+}
+
+// startSession 为已接受的连接创建会话，服务器正在关闭时直接关闭连接
+func (s *Server) startSession(conn net.Conn) {
+	connData := NewConnectionData(conn)
+	if !s.track(connData) {
+		connData.writer.close(false)
+		conn.Close()
+		<-s.connSemaphore
+		return
+	}
+	go s.serveConn(connData)
+}
+
+// acceptProxied 读取负载均衡发来的 PROXY protocol 头部，之后按玩家的真实地址创建会话
+func (s *Server) acceptProxied(conn net.Conn) {
+	proxied, err := readProxyHeader(conn, data.Get().ProxyProtocol.HeaderTimeout.Std())
+	if err != nil {
+		slog.Warn("PROXY protocol 头部无效，关闭连接", "source", conn.RemoteAddr().String(), "err", err)
+		proxyProtocolErrors.Inc()
+		conn.Close()
+		<-s.connSemaphore
+		return
+	}
+	slog.Debug("已读取 PROXY protocol 头部", "source", conn.RemoteAddr().String(), "client", proxied.RemoteAddr().String())
+	s.startSession(proxied)
+}
+
+func (s *Server) serveConn(connData *ConnectionData) {
+	connData.openLog()
+	connData.Logger().Info("新连接")
+	defer func() {
+		connData.cancelDial(errSessionEnded)
+		connData.mu.Lock()
+		if connData.proxy != nil {
+			connData.proxy.Close()
+			connData.proxy = nil
+		}
+		connData.mu.Unlock()
+
+		connData.writer.closeAndWait(time.Second)
+		connData.Conn.Close()
+		connData.Logger().Info("连接已关闭")
+		connData.stopCapture()
+		connData.closeLog()
+		<-s.connSemaphore
+		s.untrack(connData)
+	}()
+	handleBinaryConnection(connData)
+}
+
+func (s *Server) track(connData *ConnectionData) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	registry.add(connData)
+	s.wg.Add(1)
+	sessionsTotal.Inc()
+	sessionsActive.Inc()
+	return true
+}
+
+func (s *Server) untrack(connData *ConnectionData) {
+	registry.remove(connData)
+	sessionsActive.Dec()
+	s.wg.Done()
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Server) snapshotSessions() []*ConnectionData {
+	return registry.all()
+}
+
+// Shutdown 停止接受新连接，通知所有玩家并在宽限期内等待会话自然结束，
+// 宽限期或 ctx 到期后强制关闭剩余的代理与客户端连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.closing = true
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener.Close()
+	}
+
+	grace := data.Get().ShutdownGrace.Std()
+	sessions := s.snapshotSessions()
+	slog.Info("开始关闭服务器", "sessions", len(sessions), "grace", grace)
+
+	notice := fmt.Sprintf("ShadowPlayer 服务器即将关闭\n将在 %d 秒后断开连接，请尽快结束当前对局", int(grace.Seconds()))
+	for _, connData := range sessions {
+		connData.sendNotice(notice)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	remaining := s.snapshotSessions()
+	for _, connData := range remaining {
+		connData.mu.Lock()
+		if connData.proxy != nil {
+			connData.proxy.Close()
+			connData.proxy = nil
 		}
+		connData.mu.Unlock()
+		connData.Conn.Close()
+	}
+
+	s.mu.Lock()
+	tunnels := s.tunnels
+	s.tunnels = make(map[*tunnel.Session]struct{})
+	s.mu.Unlock()
+	for session := range tunnels {
+		session.Close()
+	}
+
+	slog.Info("服务器关闭完成", "drained", len(sessions)-len(remaining), "forceClosed", len(remaining))
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -147,38 +379,43 @@ func handleBinaryConnection(connData *ConnectionData) {
 	reader := bufio.NewReader(connData.Conn)
 
 	for {
-		connData.Conn.SetReadDeadline(time.Now().Add(readTimeout))
+		config := data.Get()
+		connData.Conn.SetReadDeadline(time.Now().Add(config.ReadTimeout.Std()))
 
 		var msgLen int32
 		if err := binary.Read(reader, binary.BigEndian, &msgLen); err != nil {
 			if err != io.EOF {
-				log.Printf("读取消息长度错误: %v", err)
+				connData.Logger().Warn("读取消息长度错误", "err", err)
 			}
 			return
 		}
 
-		if msgLen <= 0 || msgLen > maxMessageSize {
-			log.Printf("非法消息长度: %d", msgLen)
+		if msgLen <= 0 || msgLen > config.MaxMessageSize {
+			connData.Logger().Warn("非法消息长度", "length", msgLen)
 			return
 		}
 
 		var msgType int32
 		if err := binary.Read(reader, binary.BigEndian, &msgType); err != nil {
-			log.Printf("读取消息类型错误: %v", err)
+			connData.Logger().Warn("读取消息类型错误", "err", err)
 			return
 		}
 
 		msgData := getBuffer(msgLen)
 		if _, err := io.ReadFull(reader, msgData); err != nil {
-			log.Printf("读取消息体错误: %v", err)
+			connData.Logger().Warn("读取消息体错误", "type", _type.PacketType(msgType), "err", err)
 			putBuffer(msgData)
 			return
 		}
 
-		processBinaryMessage(connData, _type.Packet{
-			Type:  msgType,
+		packet := _type.Packet{
+			Type:  _type.PacketType(msgType),
 			Bytes: msgData,
-		})
+		}
+		connData.stats.upstream.addPacket()
+		observePacket(packet, directionUpstream)
+		connData.record(capture.ClientToProxy, packet)
+		processBinaryMessage(connData, packet)
 
 		putBuffer(msgData)
 	}
@@ -227,284 +464,48 @@ func putBuffer(buf []byte) {
 func processBinaryMessage(connData *ConnectionData, packet _type.Packet) {
 	defer func() {
 		if r := recover(); r != nil {
+			connData.Logger().Error("处理数据包时发生 panic", "type", packet.Type, "panic", r)
 			connData.Conn.Close()
 		}
 	}()
-	connData.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-
 	connData.mu.RLock()
 	proxy := connData.proxy
 	connData.mu.RUnlock()
 
 	if proxy != nil && proxy.IsConnected() {
-		if packet.Type == 109 {
-			return
-		}
-		if packet.Type == 110 {
-			packet110, err := Analysis_110(packet)
-			if err != nil {
-				log.Printf("解析110包失败: %v", err)
-				proxy.ForwardPacket(packet)
-				return
-			}
-			oldHex := packet110.PlayerHex
-			hash := sha256.Sum256([]byte(packet110.Name))
-			newHex := strings.ToUpper(fmt.Sprintf("%x", hash))
-			packet110.PlayerHex = newHex
-			log.Printf("玩家 %s 110包 PlayerHex: 原值=%s, 新值=%s", packet110.Name, oldHex, newHex)
-
-			connData.mu.Lock()
-			connData.OldPlayerHex = oldHex
-			connData.NewPlayerHex = newHex
-			connData.mu.Unlock()
-
-			modifiedPacket := Creat_110(packet110)
-			proxy.ForwardPacket(modifiedPacket)
+		if handler, ok := proxiedHandlers[packet.Type]; ok {
+			handler(connData, proxy, packet)
 			return
 		}
 		proxy.ForwardPacket(packet)
 		return
 	}
-	switch packet.Type {
-	case 160:
-		packetData, err := Analysis_160(packet)
-		if err != nil {
-			return
-		}
-		if len(packetData.playerName) == 0 {
-			return
-		}
-
-		oldConnData, exists := activeConnections.Load(packetData.playerName)
-		if exists {
-			if oldConn, ok := oldConnData.(*ConnectionData); ok && oldConn != connData {
-				oldConn.mu.Lock()
-				if oldConn.proxy != nil {
-					oldConn.proxy.Close()
-					oldConn.proxy = nil
-				}
-				oldConn.mu.Unlock()
-				oldConn.Conn.Close()
-			}
-		}
-
-		connData.mu.Lock()
-		connData.packet160 = &_type.Packet{
-			Type:  packet.Type,
-			Bytes: make([]byte, len(packet.Bytes)),
-		}
-		copy(connData.packet160.Bytes, packet.Bytes)
-		connData.mu.Unlock()
-
-		activeConnections.Store(packetData.playerName, connData)
-		sendBinaryResponse0(connData.Conn, Creat_161())
-	case 110:
-		sendBinaryResponse0(connData.Conn, Creat_117(
-			`欢迎使用 ShadowPlayer 代理服务器
-
-使用说明：
-1. 请输入需要代理的游戏服务器IP地址
-   格式：IP:端口 或 IP（默认端口5123）
-   例如：192.168.1.1:5123 或 192.168.1.1
-
-2. 然后选择是否需要去雾功能
-   输入 y/yes 启用去雾，输入其他内容禁用
-
-© RELAY-CN Team`))
-	case 118:
-		userInput, err := Analysis_118(packet)
-		if err != nil {
-			log.Printf("解析用户输入失败: %v", err)
-			return
-		}
-
-		playerName := findPlayerNameByConnData(connData)
-		if playerName == "" {
-			return
-		}
-
-		currentIP := connData.GetIP()
-		currentPort := connData.GetPort()
-
-		if currentIP == "" && currentPort == 0 {
-			ip, port := parseIPAndPort(userInput)
-			if ip != "" {
-				connData.SetIP(ip)
-				connData.SetPort(port)
-				log.Printf("玩家 %s 设置 IP: %s, Port: %d", playerName, ip, port)
-				sendBinaryResponse0(connData.Conn, Creat_117(fmt.Sprintf(
-					`服务器地址设置成功
-
-目标服务器：%s:%d
-
-是否需要启用去雾功能？
-输入 y 或 yes 启用去雾
-输入其他内容（如 n、no）禁用去雾`, ip, port)))
-			} else {
-				sendBinaryResponse0(connData.Conn, Creat_117(
-					`IP地址格式无效，请重新输入
-
-正确格式：
-IP:端口（例如：192.168.1.1:5123）
-或仅输入IP（默认端口5123，例如：192.168.1.1）
-
-请重新输入服务器地址：`))
-			}
-		} else {
-			userInputLower := strings.ToLower(strings.TrimSpace(userInput))
-			isFog := userInputLower == "y" || userInputLower == "yes"
-			connData.SetIsFog(isFog)
-			log.Printf("玩家 %s 设置 IsFog: %v", playerName, isFog)
-
-			if err := StartProxyForPlayer(playerName); err != nil {
-				log.Printf("玩家 %s 启动代理失败: %v", playerName, err)
-				sendBinaryResponse0(connData.Conn, Creat_117(
-					`代理连接失败
-
-可能的原因：
-目标服务器地址错误
-目标服务器无法访问
-网络连接问题
-
-请检查服务器地址后重试`))
-			} else {
-				log.Printf("玩家 %s 代理已启动", playerName)
-				connData.mu.RLock()
-				proxy := connData.proxy
-				savedPacket160 := connData.packet160
-				connData.mu.RUnlock()
-
-				if proxy != nil && savedPacket160 != nil {
-					proxy.ForwardPacket(*savedPacket160)
-				}
-			}
-		}
-		return
-	default:
+	if handler, ok := lobbyHandlers[packet.Type]; ok {
+		handler(connData, packet)
 		return
 	}
+	connData.Logger().Debug("代理建立前忽略数据包", "type", packet.Type)
 }
 
 func findConnectionDataByConn(conn net.Conn) *ConnectionData {
-	var foundConnData *ConnectionData
-	activeConnections.Range(func(key, value interface{}) bool {
-		if connData, ok := value.(*ConnectionData); ok {
-			if connData.Conn == conn {
-				foundConnData = connData
-				return false
-			}
-		}
-		return true
-	})
-	return foundConnData
-}
-
-func sendBinaryResponse(conn net.Conn, packet _type.Packet) error {
-	switch packet.Type {
-	case 108:
-		connData := findConnectionDataByConn(conn)
-		if connData != nil {
-			connData.mu.RLock()
-			proxy := connData.proxy
-			connData.mu.RUnlock()
-
-			if proxy != nil && proxy.IsConnected() {
-				sendTime, err := Analysis_108(packet)
-				if err != nil {
-					log.Printf("解析108包失败: %v", err)
-					return sendBinaryResponse0(conn, packet)
-				}
-
-				delay := time.Now().UnixMilli() - sendTime
-				if delay >= 0 && delay <= 500 {
-					// 可以在这里保存延迟信息，如果需要的话
-				}
-
-				packet109 := Creat_109(sendTime)
-				proxy.mu.RLock()
-				targetConn := proxy.targetConn
-				proxy.mu.RUnlock()
-
-				if targetConn != nil {
-					if err := proxy.sendPacketToTarget(targetConn, packet109); err != nil {
-						log.Printf("发送109到目标服务器失败: %v", err)
-					}
-				}
-			}
-		}
-	case 106:
-		connData := findConnectionDataByConn(conn)
-		if connData != nil {
-			connData.mu.RLock()
-			isFog := connData.IsFog
-			connData.mu.RUnlock()
-
-			if isFog {
-				modifiedPacket, err := Creat_106_ModifyFog(packet, isFog)
-				if err != nil {
-					log.Printf("修改106包失败: %v", err)
-				} else {
-					packet = modifiedPacket
-				}
-			}
+	return registry.lookupConn(conn)
+}
 
-			connData.mu.Lock()
-			if !connData.received106 {
-				connData.received106 = true
-				connData.mu.Unlock()
-
-				clientIP := getClientIPFromConnection(connData.Conn)
-				if clientIP != "" {
-					connData.mu.Lock()
-					connData.ClientIP = clientIP
-					connData.mu.Unlock()
-					log.Printf("玩家客户端IP: %s", clientIP)
-				}
-
-				connData.mu.RLock()
-				oldHex := connData.OldPlayerHex
-				newHex := connData.NewPlayerHex
-				connData.mu.RUnlock()
-
-				msg1 := "欢迎使用 ShadowPlayer 代理服务器"
-				msg2 := ""
-				if oldHex != "" && newHex != "" {
-					msg2 = fmt.Sprintf("PlayerHex已更新\n原值: %s\n新值: %s", oldHex, newHex)
-				}
-
-				sendBinaryResponse0(connData.Conn, Creat_141_System(msg1))
-				if msg2 != "" {
-					sendBinaryResponse0(connData.Conn, Creat_141_System(msg2))
-				}
-
-				go func() {
-					traceIP := getClientIPFromTrace()
-					if traceIP != "" {
-						log.Printf("Trace服务返回IP: %s", traceIP)
-					}
-					msg3 := fmt.Sprintf("网络信息\n客户端IP: %s\n外部IP: %s", clientIP, traceIP)
-					sendBinaryResponse0(connData.Conn, Creat_141_System(msg3))
-				}()
-			} else {
-				connData.mu.Unlock()
-			}
-		}
-	case 115:
-		connData := findConnectionDataByConn(conn)
-		isFog := false
-		if connData != nil {
-			connData.mu.RLock()
-			isFog = connData.IsFog
-			connData.mu.RUnlock()
-		}
-		modifiedPacket, err := Creat_115_Modify(packet, isFog)
-		if err != nil {
-			log.Printf("修改115包失败: %v", err)
-		} else {
-			packet = modifiedPacket
+// sendBinaryResponse 把目标服务器发来的包交给对应的处理函数后放入客户端的普通队列，
+// 接管 packet.Bytes 的所有权；客户端读取缓慢时阻塞
+func sendBinaryResponse(connData *ConnectionData, packet _type.Packet) error {
+	if handler, ok := downstreamHandlers[packet.Type]; ok {
+		original := packet.Bytes
+		packet = handler(connData, packet)
+		if !sameBuffer(original, packet.Bytes) {
+			putBuffer(original)
 		}
 	}
-	return sendBinaryResponse0(conn, packet)
+	return connData.writer.push(packet, data.Get().Forward.MaxBufferedBytes)
+}
+
+func sameBuffer(a []byte, b []byte) bool {
+	return len(a) > 0 && len(b) > 0 && &a[0] == &b[0]
 }
 
 func getClientIPFromConnection(conn net.Conn) string {
@@ -520,11 +521,15 @@ func getClientIPFromConnection(conn net.Conn) string {
 }
 
 func getClientIPFromTrace() string {
-	resp, err := http.GetRequest("https://image.nebulapause.com/cdn-cgi/trace", nil, map[string]string{
+	traceURL := data.Get().TraceURL
+	if traceURL == "" {
+		return ""
+	}
+	resp, err := http.GetRequest(traceURL, nil, map[string]string{
 		"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/142.0.0.0 Safari/537.36 Edg/142.0.0.0",
 	})
 	if err != nil {
-		log.Printf("获取Trace IP失败: %v", err)
+		slog.Warn("获取Trace IP失败", "err", err)
 		return ""
 	}
 
@@ -540,81 +545,98 @@ func getClientIPFromTrace() string {
 	return ""
 }
 
-func sendBinaryResponse0(conn net.Conn, packet _type.Packet) error {
-	total := 8 + len(packet.Bytes)
-	out := make([]byte, total)
-	binary.BigEndian.PutUint32(out[0:4], uint32(len(packet.Bytes)))
-	binary.BigEndian.PutUint32(out[4:8], uint32(packet.Type))
-	copy(out[8:], packet.Bytes)
-	_, err := conn.Write(out)
-	return err
+// sendBinaryResponse0 把 ShadowPlayer 自己生成的包放入客户端的优先队列，先于转发的游戏帧写出
+func sendBinaryResponse0(connData *ConnectionData, packet _type.Packet) error {
+	return connData.writer.inject(packet)
 }
 
 func refreshTheTeam() {
-	activeConnections.Range(func(key, value interface{}) bool {
-		if connData, ok := value.(*ConnectionData); ok {
-			sendBinaryResponse0(connData.Conn, Creat_115(activeConnections, connData.Conn))
-		}
-		return true
-	})
+	players := registry.players()
+	names := make([]string, 0, len(players))
+	for _, connData := range players {
+		names = append(names, registry.nameOf(connData))
+	}
+	packet := Creat_115(names)
+	for _, connData := range players {
+		sendBinaryResponse0(connData, packet)
+	}
 }
 
+// RefreshPing 向所有玩家发送心跳，客户端的 109 应答用于计算 RTT
 func RefreshPing() {
-	var packet = Creat_108()
-	activeConnections.Range(func(key, value interface{}) bool {
-		if connData, ok := value.(*ConnectionData); ok {
-			sendBinaryResponse0(connData.Conn, packet)
-		}
-		return true
-	})
+	var packet = Creat_108(time.Now().UnixMilli())
+	for _, connData := range registry.players() {
+		sendBinaryResponse0(connData, packet)
+	}
 }
 
 func GetConnectionData(playerName string) (*ConnectionData, bool) {
-	value, ok := activeConnections.Load(playerName)
-	if !ok {
-		return nil, false
-	}
-	connData, ok := value.(*ConnectionData)
-	return connData, ok
-}
-
-func findPlayerNameByConnData(targetConnData *ConnectionData) string {
-	var playerName string
-	activeConnections.Range(func(key, value interface{}) bool {
-		if connData, ok := value.(*ConnectionData); ok {
-			if connData == targetConnData {
-				if name, ok := key.(string); ok {
-					playerName = name
-					return false
-				}
-			}
-		}
-		return true
-	})
-	return playerName
+	connData := registry.lookupName(playerName)
+	return connData, connData != nil
 }
 
+func findPlayerNameByConnData(connData *ConnectionData) string {
+	return registry.nameOf(connData)
+}
+
+// parseIPAndPort 解析 host、host:port、[v6]:port 以及不带端口的 IPv6 地址，
+// 格式无效时返回空字符串
 func parseIPAndPort(input string) (string, int32) {
 	input = strings.TrimSpace(input)
 	if input == "" {
 		return "", 0
 	}
 
-	defaultPort := int32(5123)
+	defaultPort := data.Get().DefaultTargetPort
 
-	if strings.Contains(input, ":") {
-		parts := strings.SplitN(input, ":", 2)
-		if len(parts) == 2 {
-			ip := strings.TrimSpace(parts[0])
-			portStr := strings.TrimSpace(parts[1])
-			port, err := strconv.ParseInt(portStr, 10, 32)
-			if err != nil {
-				log.Printf("端口解析失败: %s, 使用默认端口 %d", portStr, defaultPort)
-				port = int64(defaultPort)
-			}
-			return ip, int32(port)
+	if addr, err := netip.ParseAddr(strings.Trim(input, "[]")); err == nil && addr.Zone() == "" {
+		return addr.String(), defaultPort
+	}
+
+	host, portStr := input, ""
+	if strings.HasPrefix(input, "[") || strings.Count(input, ":") == 1 {
+		var err error
+		host, portStr, err = net.SplitHostPort(input)
+		if err != nil {
+			return "", 0
 		}
 	}
 
-	return input, defaultPort
+	port := defaultPort
+	if portStr != "" {
+		parsed, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || parsed == 0 {
+			return "", 0
+		}
+		port = int32(parsed)
+	}
+
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if addr.Zone() != "" {
+			return "", 0
+		}
+		return addr.String(), port
+	}
+	if !isValidHostname(host) {
+		return "", 0
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port
+}
+
+func isValidHostname(host string) bool {
+	host = strings.TrimSuffix(host, ".")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
 }
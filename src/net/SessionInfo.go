@@ -0,0 +1,211 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type trafficCounter struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+func (tc *trafficCounter) addPacket() {
+	tc.packets.Add(1)
+}
+
+func (tc *trafficCounter) addBytes(n int) {
+	tc.bytes.Add(uint64(n))
+}
+
+func (tc *trafficCounter) snapshot() TrafficInfo {
+	return TrafficInfo{Packets: tc.packets.Load(), Bytes: tc.bytes.Load()}
+}
+
+// sessionStats 以客户端为视角统计流量：upstream 为客户端发来的数据，downstream 为发给客户端的数据
+type sessionStats struct {
+	upstream   trafficCounter
+	downstream trafficCounter
+
+	stalls       atomic.Uint64 // 因目标服务器读取缓慢暂停读取客户端的次数
+	stallTime    atomic.Int64  // 已结束的暂停累计时长
+	stalledSince atomic.Int64  // 当前暂停的开始时间（UnixNano），未暂停时为 0
+}
+
+// statsConn 统计客户端连接上的字节数，包数由读写两端按帧统计
+type statsConn struct {
+	net.Conn
+	stats *sessionStats
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.upstream.addBytes(n)
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.downstream.addBytes(n)
+	return n, err
+}
+
+type TrafficInfo struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// ForwardInfo 描述发往目标服务器的队列与暂停情况
+type ForwardInfo struct {
+	QueuedBytes  int     `json:"queuedBytes"`
+	Stalled      bool    `json:"stalled"`
+	Stalls       uint64  `json:"stalls"`
+	StallSeconds float64 `json:"stallSeconds"` // 累计暂停时长，含正在进行的暂停
+}
+
+type SessionInfo struct {
+	ID          string      `json:"id"`
+	PlayerName  string      `json:"playerName"`
+	ClientIP    string      `json:"clientIp"`
+	Target      string      `json:"target"`
+	RemoteAddr  string      `json:"remoteAddr"`
+	Egress      string      `json:"egress"` // 连接目标服务器时使用的本机源地址
+	IsFog       bool        `json:"isFog"`
+	ConnectedAt time.Time   `json:"connectedAt"`
+	ProxyState  string      `json:"proxyState"`
+	Upstream    TrafficInfo `json:"upstream"`
+	Downstream  TrafficInfo `json:"downstream"`
+	Forward     ForwardInfo `json:"forward"`
+	RTT         RTTInfo     `json:"rtt"`
+}
+
+func (cd *ConnectionData) Info() SessionInfo {
+	cd.mu.RLock()
+	info := SessionInfo{
+		ID:          cd.ID,
+		PlayerName:  cd.PlayerName,
+		ClientIP:    cd.ClientIP,
+		IsFog:       cd.IsFog,
+		ConnectedAt: cd.ConnectedAt,
+		ProxyState:  "lobby",
+	}
+	if cd.IP != "" {
+		info.Target = net.JoinHostPort(cd.IP, strconv.Itoa(int(cd.Port)))
+	}
+	proxy := cd.proxy
+	cd.mu.RUnlock()
+
+	if info.ClientIP == "" {
+		info.ClientIP = getClientIPFromConnection(cd.Conn)
+	}
+	if proxy != nil {
+		if proxy.IsConnected() {
+			info.ProxyState = "connected"
+			info.RemoteAddr = proxy.RemoteAddr().String()
+			if egressAddr := proxy.EgressAddr(); egressAddr.IsValid() {
+				info.Egress = egressAddr.String()
+			}
+		} else {
+			info.ProxyState = "closed"
+		}
+		if proxy.writer != nil {
+			info.Forward.QueuedBytes = proxy.writer.queuedBytes()
+		}
+	}
+	info.Upstream = cd.stats.upstream.snapshot()
+	info.Downstream = cd.stats.downstream.snapshot()
+	info.RTT = cd.RTT()
+
+	stallTime := time.Duration(cd.stats.stallTime.Load())
+	if since := cd.stats.stalledSince.Load(); since != 0 {
+		info.Forward.Stalled = true
+		stallTime += time.Since(time.Unix(0, since))
+	}
+	info.Forward.Stalls = cd.stats.stalls.Load()
+	info.Forward.StallSeconds = stallTime.Seconds()
+	return info
+}
+
+func (s *Server) Sessions() []SessionInfo {
+	sessions := s.snapshotSessions()
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, connData := range sessions {
+		result = append(result, connData.Info())
+	}
+	return result
+}
+
+func (s *Server) findSession(id string) *ConnectionData {
+	return registry.lookupID(id)
+}
+
+func (s *Server) Session(id string) (SessionInfo, bool) {
+	connData := s.findSession(id)
+	if connData == nil {
+		return SessionInfo{}, false
+	}
+	return connData.Info(), true
+}
+
+// Kick 通知玩家后断开会话
+func (s *Server) Kick(id string, reason string) bool {
+	connData := s.findSession(id)
+	if connData == nil {
+		return false
+	}
+	msg := "你已被管理员断开连接"
+	if reason != "" {
+		msg += "\n原因: " + reason
+	}
+	connData.disconnect(msg)
+	return true
+}
+
+// sendNotice 向玩家发送通知：对局中用系统聊天消息，仍在大厅时用对话框
+func (cd *ConnectionData) sendNotice(msg string) {
+	cd.mu.RLock()
+	proxy := cd.proxy
+	cd.mu.RUnlock()
+
+	if proxy != nil && proxy.IsConnected() {
+		sendBinaryResponse0(cd, Creat_141_System(msg))
+	} else {
+		sendBinaryResponse0(cd, Creat_117(msg))
+	}
+}
+
+// disconnect 向玩家发送系统消息后关闭代理和客户端连接
+func (cd *ConnectionData) disconnect(msg string) {
+	cd.sendNotice(msg)
+
+	cd.mu.Lock()
+	if cd.proxy != nil {
+		cd.proxy.Close()
+		cd.proxy = nil
+	}
+	cd.mu.Unlock()
+	cd.writer.closeAndWait(time.Second)
+	cd.Conn.Close()
+}
+
+func (s *Server) SendSystemMessage(id string, msg string) bool {
+	connData := s.findSession(id)
+	if connData == nil {
+		return false
+	}
+	return sendBinaryResponse0(connData, Creat_141_System(msg)) == nil
+}
+
+// Broadcast 向所有会话发送系统消息，返回发送成功的数量
+func (s *Server) Broadcast(msg string) int {
+	sent := 0
+	packet := Creat_141_System(msg)
+	for _, connData := range s.snapshotSessions() {
+		if sendBinaryResponse0(connData, packet) == nil {
+			sent++
+		}
+	}
+	return sent
+}
@@ -0,0 +1,13 @@
+//go:build !linux || 386
+
+package net
+
+import (
+	"net"
+	"time"
+)
+
+// tcpRTT 在没有 TCP_INFO 的平台上不可用，到目标服务器的 RTT 只来自建立连接的耗时
+func tcpRTT(conn net.Conn) (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,155 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"ShadowPlayer/src/tunnel"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const tunnelHandshakeTimeout = 10 * time.Second
+
+var tunnelTLSCache struct {
+	mu     sync.Mutex
+	config *data.Config
+	tls    *tls.Config
+	err    error
+}
+
+// currentTunnelTLS 返回与当前配置对应的 TLS 配置，配置热重载后重新读取证书
+func currentTunnelTLS() (*tls.Config, error) {
+	config := data.Get()
+	tunnelTLSCache.mu.Lock()
+	defer tunnelTLSCache.mu.Unlock()
+	if tunnelTLSCache.config != config {
+		tunnelTLSCache.tls, tunnelTLSCache.err = loadTunnelTLS(config.Tunnel)
+		tunnelTLSCache.config = config
+	}
+	return tunnelTLSCache.tls, tunnelTLSCache.err
+}
+
+func loadTunnelTLS(config data.TunnelConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(data.ResolvePath(config.CertFile), data.ResolvePath(config.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("读取隧道证书失败: %w", err)
+	}
+	pem, err := os.ReadFile(data.ResolvePath(config.ClientCAFile))
+	if err != nil {
+		return nil, fmt.Errorf("读取隧道客户端 CA 失败: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("客户端 CA 文件中没有有效的证书: %s", config.ClientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		NextProtos:   []string{tunnel.ALPN},
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ListenAndServeTunnel 在 tunnel.listen 上接受 shadowplayer client 建立的 TLS 隧道，
+// 隧道中的每条流与游戏端口上的连接一样作为独立的玩家会话处理
+func (s *Server) ListenAndServeTunnel() error {
+	if _, err := currentTunnelTLS(); err != nil {
+		return err
+	}
+	listener, err := tls.Listen("tcp", data.Get().Tunnel.Listen, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return currentTunnelTLS()
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if !s.addListener(listener) {
+		return ErrServerClosed
+	}
+	defer listener.Close()
+
+	slog.Info("隧道入口已启动", "listen", listener.Addr().String())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			slog.Warn("接受隧道连接错误", "err", err)
+			continue
+		}
+		go s.serveTunnel(conn.(*tls.Conn))
+	}
+}
+
+// addTunnel 登记一条隧道，关闭服务器时在会话处理完后一并关闭；服务器正在关闭时关闭它并返回 false
+func (s *Server) addTunnel(session *tunnel.Session) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		session.Close()
+		return false
+	}
+	s.tunnels[session] = struct{}{}
+	return true
+}
+
+func (s *Server) removeTunnel(session *tunnel.Session) {
+	s.mu.Lock()
+	delete(s.tunnels, session)
+	s.mu.Unlock()
+}
+
+func (s *Server) serveTunnel(conn *tls.Conn) {
+	logger := slog.With("tunnel", conn.RemoteAddr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), tunnelHandshakeTimeout)
+	err := conn.HandshakeContext(ctx)
+	cancel()
+	if err != nil {
+		logger.Warn("隧道握手失败", "err", err)
+		tunnelHandshakeFailures.Inc()
+		conn.Close()
+		return
+	}
+
+	state := conn.ConnectionState()
+	logger = logger.With("cn", state.PeerCertificates[0].Subject.CommonName)
+	logger.Info("隧道已连接")
+	tunnelsActive.Inc()
+	defer tunnelsActive.Dec()
+
+	session := tunnel.Server(conn)
+	if !s.addTunnel(session) {
+		logger.Info("服务器正在关闭，断开隧道")
+		return
+	}
+	defer s.removeTunnel(session)
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			logger.Info("隧道已断开", "err", err)
+			return
+		}
+		select {
+		case s.connSemaphore <- struct{}{}:
+			logger.Debug("隧道中打开了新会话", "streams", session.NumStreams())
+			s.startSession(stream)
+		default:
+			stream.Close()
+			connectionsRejected.Inc()
+			logger.Warn("连接数已达上限，拒绝隧道中的新会话")
+		}
+	}
+}
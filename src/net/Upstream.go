@@ -0,0 +1,302 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	upstreamStageConnect = "连接"
+	upstreamStageAuth    = "认证"
+	upstreamStageRequest = "请求目标"
+)
+
+// UpstreamError 描述经上游代理连接目标时在哪一步失败
+type UpstreamError struct {
+	Proxy string // 配置中的代理名称
+	Stage string
+	Err   error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("上游代理 %s %s失败: %v", e.Proxy, e.Stage, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+type upstreamRoute struct {
+	name     string
+	kind     string
+	address  string
+	username string
+	password string
+	hosts    []string
+	cidrs    []netip.Prefix
+}
+
+var upstreamCache struct {
+	mu     sync.Mutex
+	config *data.Config
+	routes []*upstreamRoute
+}
+
+// currentUpstreams 返回与当前配置对应的上游代理，配置热重载后自动重新编译
+func currentUpstreams() []*upstreamRoute {
+	config := data.Get()
+	upstreamCache.mu.Lock()
+	defer upstreamCache.mu.Unlock()
+	if upstreamCache.config != config {
+		upstreamCache.routes = compileUpstreams(config.Upstreams)
+		upstreamCache.config = config
+	}
+	return upstreamCache.routes
+}
+
+func compileUpstreams(upstreams []data.UpstreamProxy) []*upstreamRoute {
+	routes := make([]*upstreamRoute, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		route := &upstreamRoute{
+			name:     upstream.Name,
+			kind:     upstream.Type,
+			address:  upstream.Address,
+			username: upstream.Username,
+			password: upstream.Password,
+		}
+		for _, host := range upstream.Hosts {
+			route.hosts = append(route.hosts, normalizeHost(host))
+		}
+		// 网段已在配置校验时检查过，这里忽略解析错误
+		for _, cidr := range upstream.CIDRs {
+			if prefix, err := netip.ParsePrefix(cidr); err == nil {
+				route.cidrs = append(route.cidrs, prefix.Masked())
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+func (r *upstreamRoute) matches(name string, addr netip.Addr) bool {
+	for _, pattern := range r.hosts {
+		if matchHostPattern(name, pattern) {
+			return true
+		}
+	}
+	for _, prefix := range r.cidrs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectUpstream 返回连接 host 解析出的 addr 时经过的代理，直接连接时返回 nil
+func selectUpstream(host string, addr netip.Addr) *upstreamRoute {
+	name := normalizeHost(host)
+	for _, route := range currentUpstreams() {
+		if route.matches(name, addr) {
+			return route
+		}
+	}
+	return nil
+}
+
+// dialTarget 连接已通过策略检查的目标地址，按配置经上游代理连接，
+// 或从 egress 中为 player 选择的源地址直接连接。返回所用的上游代理，直接连接时为 nil
+func dialTarget(ctx context.Context, dialer *net.Dialer, player string, host string, addr netip.AddrPort) (net.Conn, *upstreamRoute, error) {
+	route := selectUpstream(host, addr.Addr())
+	if route == nil {
+		conn, err := egressDialer(dialer, player, addr.Addr()).DialContext(ctx, "tcp", addr.String())
+		return conn, nil, err
+	}
+	conn, err := route.dial(ctx, dialer, addr)
+	return conn, route, err
+}
+
+func (r *upstreamRoute) dial(ctx context.Context, dialer *net.Dialer, target netip.AddrPort) (net.Conn, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", r.address)
+	if err != nil {
+		return nil, r.fail(upstreamStageConnect, err)
+	}
+
+	// 握手同样受 ctx 的超时与取消约束
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	var tunnel net.Conn
+	switch r.kind {
+	case "http":
+		tunnel, err = r.httpConnect(conn, target)
+	default:
+		tunnel, err = r.socks5Connect(conn, target)
+	}
+	if err == nil && !stop() {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+func (r *upstreamRoute) fail(stage string, err error) error {
+	return &UpstreamError{Proxy: r.name, Stage: stage, Err: err}
+}
+
+var socks5Replies = map[byte]string{
+	1: "代理服务器内部错误",
+	2: "代理规则不允许该连接",
+	3: "代理所在网络不可达",
+	4: "代理无法访问目标主机",
+	5: "目标拒绝连接",
+	6: "连接超时",
+	7: "代理不支持 CONNECT",
+	8: "代理不支持该地址类型",
+}
+
+// socks5Connect 按 RFC 1928 / RFC 1929 建立到目标的隧道，目标总是以 IP 地址发送
+func (r *upstreamRoute) socks5Connect(conn net.Conn, target netip.AddrPort) (net.Conn, error) {
+	methods := []byte{0x00}
+	if r.username != "" {
+		methods = []byte{0x02}
+	}
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return nil, r.fail(upstreamStageConnect, err)
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return nil, r.fail(upstreamStageConnect, err)
+	}
+	if reply[0] != 0x05 {
+		return nil, r.fail(upstreamStageConnect, errors.New("不是 SOCKS5 代理"))
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		auth := []byte{0x01, byte(len(r.username))}
+		auth = append(auth, r.username...)
+		auth = append(auth, byte(len(r.password)))
+		auth = append(auth, r.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return nil, r.fail(upstreamStageAuth, err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return nil, r.fail(upstreamStageAuth, err)
+		}
+		if reply[1] != 0x00 {
+			return nil, r.fail(upstreamStageAuth, errors.New("用户名或密码错误"))
+		}
+	default:
+		return nil, r.fail(upstreamStageAuth, errors.New("代理不接受配置的认证方式"))
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	addr := target.Addr().Unmap()
+	if addr.Is4() {
+		request = append(request, 0x01)
+	} else {
+		request = append(request, 0x04)
+	}
+	request = append(request, addr.AsSlice()...)
+	request = binary.BigEndian.AppendUint16(request, target.Port())
+	if _, err := conn.Write(request); err != nil {
+		return nil, r.fail(upstreamStageRequest, err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, r.fail(upstreamStageRequest, err)
+	}
+	if header[1] != 0x00 {
+		message, ok := socks5Replies[header[1]]
+		if !ok {
+			message = fmt.Sprintf("代理返回错误码 %d", header[1])
+		}
+		return nil, r.fail(upstreamStageRequest, errors.New(message))
+	}
+	var boundLen int
+	switch header[3] {
+	case 0x01:
+		boundLen = 4
+	case 0x04:
+		boundLen = 16
+	case 0x03:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return nil, r.fail(upstreamStageRequest, err)
+		}
+		boundLen = int(size[0])
+	default:
+		return nil, r.fail(upstreamStageRequest, fmt.Errorf("代理返回未知的地址类型 %d", header[3]))
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(boundLen+2)); err != nil {
+		return nil, r.fail(upstreamStageRequest, err)
+	}
+	return conn, nil
+}
+
+// httpConnect 通过 HTTP CONNECT 建立到目标的隧道
+func (r *upstreamRoute) httpConnect(conn net.Conn, target netip.AddrPort) (net.Conn, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
+	if r.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(r.username + ":" + r.password))
+		fmt.Fprintf(&sb, "Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	sb.WriteString("\r\n")
+	if _, err := io.WriteString(conn, sb.String()); err != nil {
+		return nil, r.fail(upstreamStageConnect, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, r.fail(upstreamStageRequest, err)
+	}
+	switch {
+	case response.StatusCode == http.StatusProxyAuthRequired:
+		return nil, r.fail(upstreamStageAuth, fmt.Errorf("代理要求认证 (%s)", response.Status))
+	case response.StatusCode/100 != 2:
+		return nil, r.fail(upstreamStageRequest, fmt.Errorf("代理返回 %s", response.Status))
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读出解析代理响应时多读入缓冲区的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// NetConn 返回底层连接，用于读取 TCP_INFO
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,97 @@
+package net
+
+import (
+	"ShadowPlayer/src/data"
+	"ShadowPlayer/src/websocket"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var webSocketCertCache struct {
+	mu     sync.Mutex
+	config *data.Config
+	cert   *tls.Certificate
+	err    error
+}
+
+// currentWebSocketCert 返回与当前配置对应的 wss 证书，配置热重载后重新读取
+func currentWebSocketCert() (*tls.Certificate, error) {
+	config := data.Get()
+	webSocketCertCache.mu.Lock()
+	defer webSocketCertCache.mu.Unlock()
+	if webSocketCertCache.config != config {
+		cert, err := tls.LoadX509KeyPair(data.ResolvePath(config.WebSocket.CertFile), data.ResolvePath(config.WebSocket.KeyFile))
+		webSocketCertCache.cert, webSocketCertCache.err = &cert, err
+		webSocketCertCache.config = config
+	}
+	return webSocketCertCache.cert, webSocketCertCache.err
+}
+
+// ListenAndServeWebSocket 在 webSocket.listen 上接受 WebSocket 连接，
+// 每条连接适配为字节流后与游戏端口上的连接一样作为玩家会话处理
+func (s *Server) ListenAndServeWebSocket() error {
+	config := data.Get().WebSocket
+	useTLS := config.CertFile != ""
+	if useTLS {
+		if _, err := currentWebSocketCert(); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		return err
+	}
+	if useTLS {
+		listener = tls.NewListener(listener, &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return currentWebSocketCert()
+			},
+			MinVersion: tls.VersionTLS12,
+		})
+	}
+	if !s.addListener(listener) {
+		return ErrServerClosed
+	}
+
+	httpServer := &http.Server{
+		Handler:           http.HandlerFunc(s.handleWebSocket),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("WebSocket 入口已启动", "listen", listener.Addr().String(), "tls", useTLS, "path", config.Path)
+	err = httpServer.Serve(listener)
+	if s.isClosing() {
+		return ErrServerClosed
+	}
+	return err
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	config := data.Get()
+	if r.URL.Path != config.WebSocket.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	select {
+	case s.connSemaphore <- struct{}{}:
+	default:
+		connectionsRejected.Inc()
+		slog.Warn("连接数已达上限，拒绝新连接", "client", r.RemoteAddr)
+		http.Error(w, "连接数已达上限", http.StatusServiceUnavailable)
+		return
+	}
+	ws, subprotocol, err := websocket.Upgrade(w, r, websocket.Subprotocols)
+	if err != nil {
+		<-s.connSemaphore
+		slog.Debug("WebSocket 握手失败", "client", r.RemoteAddr, "err", err)
+		return
+	}
+	// 按字节流发送时一条消息可能包含多个帧，这里只限制单条消息不超过最大的帧
+	ws.MaxMessageSize = int(config.MaxMessageSize) + 8
+	slog.Debug("已接受 WebSocket 连接", "client", r.RemoteAddr, "subprotocol", subprotocol)
+	s.startSession(websocket.NewNetConn(ws, subprotocol))
+}
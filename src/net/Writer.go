@@ -0,0 +1,265 @@
+package net
+
+import (
+	"ShadowPlayer/src/metrics"
+	_type "ShadowPlayer/src/type"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var errWriterClosed = errors.New("连接已关闭")
+
+// writerHooks 是 frameWriter 的回调，均在写协程中调用
+type writerHooks struct {
+	timeout func() time.Duration      // 每个帧的写超时
+	written func(packet _type.Packet) // 帧已成功写出（flush 之后）
+	failed  func(err error)           // 写入失败，之后不再写出任何帧
+	queued  *metrics.Gauge            // 可选，统计普通队列中的字节数
+}
+
+type queuedFrame struct {
+	packet _type.Packet
+	pooled bool // 内容来自 getBuffer，写出后归还
+}
+
+// frameWriter 是一个连接上唯一的写协程，保证帧不会互相穿插。
+// 优先队列存放 ShadowPlayer 自己注入的消息，总是先于普通队列中转发的游戏帧写出；
+// 普通队列按字节数限制容量。写入经过 bufio 合并，队列清空时才 flush
+type frameWriter struct {
+	conn  net.Conn
+	hooks writerHooks
+
+	mu          sync.Mutex
+	priority    []queuedFrame
+	normal      []queuedFrame
+	normalBytes int
+	closed      bool
+	drain       bool // 关闭后仍写完已排队的帧
+
+	ready chan struct{} // 有新帧或被关闭时通知写协程
+	space chan struct{} // 普通队列有帧被取出时通知等待中的调用方
+	done  chan struct{} // 写协程退出后关闭
+}
+
+func newFrameWriter(conn net.Conn, hooks writerHooks) *frameWriter {
+	w := &frameWriter{
+		conn:  conn,
+		hooks: hooks,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func queuedSize(packet _type.Packet) int {
+	return 8 + len(packet.Bytes)
+}
+
+func releaseFrames(frames []queuedFrame) {
+	for _, frame := range frames {
+		if frame.pooled {
+			putBuffer(frame.packet.Bytes)
+		}
+	}
+}
+
+// inject 把消息放入优先队列，不受容量限制
+func (w *frameWriter) inject(packet _type.Packet) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return errWriterClosed
+	}
+	w.priority = append(w.priority, queuedFrame{packet: packet})
+	w.mu.Unlock()
+	notify(w.ready)
+	return nil
+}
+
+// tryPush 在预算内时把 pooled 的包放入普通队列并返回 true。队列为空时总是接受，
+// 保证超过预算的单个大包也能发出；已关闭时归还缓冲区并返回 true
+func (w *frameWriter) tryPush(packet _type.Packet, limit int) bool {
+	size := queuedSize(packet)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		putBuffer(packet.Bytes)
+		return true
+	}
+	if w.normalBytes > 0 && w.normalBytes+size > limit {
+		w.mu.Unlock()
+		return false
+	}
+	w.normal = append(w.normal, queuedFrame{packet: packet, pooled: true})
+	w.normalBytes += size
+	w.mu.Unlock()
+
+	if w.hooks.queued != nil {
+		w.hooks.queued.Add(int64(size))
+	}
+	notify(w.ready)
+	return true
+}
+
+// push 与 tryPush 相同，但在超出预算时阻塞，直到有空间或连接关闭
+func (w *frameWriter) push(packet _type.Packet, limit int) error {
+	for !w.tryPush(packet, limit) {
+		select {
+		case <-w.space:
+		case <-w.done:
+			putBuffer(packet.Bytes)
+			return errWriterClosed
+		}
+	}
+	if w.isClosed() {
+		return errWriterClosed
+	}
+	return nil
+}
+
+func (w *frameWriter) isClosed() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closed
+}
+
+func (w *frameWriter) queuedBytes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.normalBytes
+}
+
+// close 停止接受新的帧。drain 为 true 时写协程会先写完已排队的帧，否则直接丢弃
+func (w *frameWriter) close(drain bool) {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		w.drain = drain
+	}
+	w.mu.Unlock()
+	notify(w.ready)
+}
+
+// closeAndWait 关闭并等待已排队的帧写完，最多等待 timeout
+func (w *frameWriter) closeAndWait(timeout time.Duration) {
+	w.close(true)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-w.done:
+	case <-timer.C:
+	}
+}
+
+// next 取出下一个要写的帧。队列为空时返回 ok 为 false，exit 表示写协程应当退出
+func (w *frameWriter) next() (frame queuedFrame, ok bool, exit bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed && !w.drain {
+		return queuedFrame{}, false, true
+	}
+	if len(w.priority) > 0 {
+		frame = w.priority[0]
+		w.priority[0] = queuedFrame{}
+		w.priority = w.priority[1:]
+		return frame, true, false
+	}
+	if len(w.normal) > 0 {
+		frame = w.normal[0]
+		w.normal[0] = queuedFrame{}
+		w.normal = w.normal[1:]
+		size := queuedSize(frame.packet)
+		w.normalBytes -= size
+		if w.hooks.queued != nil {
+			w.hooks.queued.Add(-int64(size))
+		}
+		notify(w.space)
+		return frame, true, false
+	}
+	return queuedFrame{}, false, w.closed
+}
+
+// shutdown 在写协程退出时丢弃剩余的帧
+func (w *frameWriter) shutdown() {
+	w.mu.Lock()
+	w.closed = true
+	frames := append(w.priority, w.normal...)
+	dropped := w.normalBytes
+	w.priority, w.normal, w.normalBytes = nil, nil, 0
+	w.mu.Unlock()
+
+	releaseFrames(frames)
+	if w.hooks.queued != nil {
+		w.hooks.queued.Add(-int64(dropped))
+	}
+	close(w.done)
+}
+
+func (w *frameWriter) run() {
+	defer w.shutdown()
+
+	bw := bufio.NewWriterSize(w.conn, 32*1024)
+	header := make([]byte, 8)
+	var pending []queuedFrame // 已写入缓冲区、尚未 flush 的帧
+
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		err := bw.Flush()
+		if err == nil {
+			for _, frame := range pending {
+				w.hooks.written(frame.packet)
+			}
+		}
+		releaseFrames(pending)
+		clear(pending)
+		pending = pending[:0]
+		return err
+	}
+	fail := func(err error) {
+		releaseFrames(pending)
+		w.hooks.failed(err)
+	}
+
+	for {
+		frame, ok, exit := w.next()
+		if !ok {
+			if err := flush(); err != nil {
+				fail(err)
+				return
+			}
+			if exit {
+				return
+			}
+			<-w.ready
+			continue
+		}
+
+		w.conn.SetWriteDeadline(time.Now().Add(w.hooks.timeout()))
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(frame.packet.Bytes)))
+		binary.BigEndian.PutUint32(header[4:8], uint32(frame.packet.Type))
+		pending = append(pending, frame)
+		if _, err := bw.Write(header); err != nil {
+			fail(err)
+			return
+		}
+		if _, err := bw.Write(frame.packet.Bytes); err != nil {
+			fail(err)
+			return
+		}
+	}
+}
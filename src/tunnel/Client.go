@@ -0,0 +1,161 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const clientUsage = `用法: shadowplayer client [选项]
+
+在本机监听游戏连接，经双向认证的 TLS 隧道转发到 ShadowPlayer 服务器。
+在游戏中连接 -listen 指定的地址即可，多个游戏连接共用同一条隧道。
+
+选项:
+`
+
+const dialTimeout = 10 * time.Second
+
+// RunClient 执行 client 子命令，返回进程退出码
+func RunClient(args []string) int {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:5123", "本地监听地址，游戏中连接该地址")
+	server := flags.String("server", "", "ShadowPlayer 服务器的隧道地址 host:port（必填）")
+	certFile := flags.String("cert", "", "客户端证书文件（必填）")
+	keyFile := flags.String("key", "", "客户端私钥文件（必填）")
+	caFile := flags.String("ca", "", "校验服务器证书的 CA 文件，留空时使用系统根证书")
+	serverName := flags.String("name", "", "服务器证书中的名称，留空时使用 -server 的主机部分")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), clientUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *server == "" || *certFile == "" || *keyFile == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	tlsConfig, err := clientTLSConfig(*server, *certFile, *keyFile, *caFile, *serverName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "监听 %s 失败: %v\n", *listen, err)
+		return 1
+	}
+	defer listener.Close()
+
+	c := &client{server: *server, tlsConfig: tlsConfig}
+	slog.Info("正在监听", "listen", listener.Addr().String(), "server", *server)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "接受连接失败: %v\n", err)
+			return 1
+		}
+		go c.serve(conn)
+	}
+}
+
+func clientTLSConfig(server, certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取客户端证书失败: %w", err)
+	}
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(server)
+		if err != nil {
+			return nil, fmt.Errorf("服务器地址无效: %q", server)
+		}
+		serverName = host
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ServerName:   serverName,
+		NextProtos:   []string{ALPN},
+		MinVersion:   tls.VersionTLS13,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 CA 文件失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA 文件中没有有效的证书: %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// client 复用同一条隧道，隧道断开后在下一个游戏连接到来时重新建立
+type client struct {
+	server    string
+	tlsConfig *tls.Config
+
+	mu      sync.Mutex
+	session *Session
+}
+
+func (c *client) open() (*Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session != nil {
+		if stream, err := c.session.Open(); err == nil {
+			return stream, nil
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", c.server, c.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("隧道已连接", "server", conn.RemoteAddr().String())
+	session := Client(conn)
+	go func() {
+		<-session.Done()
+		slog.Info("隧道已断开", "err", session.Err())
+	}()
+	c.session = session
+	return session.Open()
+}
+
+func (c *client) serve(conn net.Conn) {
+	defer conn.Close()
+	stream, err := c.open()
+	if err != nil {
+		slog.Warn("连接隧道服务器失败", "err", err)
+		return
+	}
+	defer stream.Close()
+	slog.Info("游戏连接已接入隧道", "client", conn.RemoteAddr().String())
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(stream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, stream)
+		done <- struct{}{}
+	}()
+	<-done
+	slog.Info("游戏连接已结束", "client", conn.RemoteAddr().String())
+}
@@ -0,0 +1,491 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ALPN 是隧道使用的 TLS 应用层协议名
+const ALPN = "shadowplayer-tunnel/1"
+
+// 帧格式：类型(1 字节) 流 ID(4 字节) 长度(4 字节) 数据。
+// 只有 frameData 带数据，其余类型的长度字段另有含义或为 0
+const (
+	frameOpen   byte = 1 // 客户端打开新流
+	frameData   byte = 2
+	frameWindow byte = 3 // 长度字段为归还给对端的发送窗口
+	frameClose  byte = 4 // 关闭流，两个方向同时结束
+	framePing   byte = 5 // 长度字段为序号，对端以 framePong 原样返回
+	framePong   byte = 6
+)
+
+const (
+	headerSize    = 9
+	maxFrameData  = 32 * 1024
+	streamWindow  = 256 * 1024 // 每条流在对端读走之前最多发送的字节数
+	maxStreams    = 256
+	acceptBacklog = 32
+
+	keepAliveInterval = 15 * time.Second
+	idleTimeout       = 3 * keepAliveInterval // 超过该时间没有收到任何帧时认为隧道已断开
+	frameWriteTimeout = 30 * time.Second
+)
+
+var errProtocol = errors.New("隧道协议错误")
+
+// Session 在一条连接上承载多条双向的流。流只能由客户端打开，
+// 每条流有独立的发送窗口，一个玩家读取缓慢不会阻塞同一隧道中的其他玩家
+type Session struct {
+	conn   net.Conn
+	client bool
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	err     error
+
+	accepts   chan *Stream
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Client 在已建立的连接上创建客户端一侧的会话，并定期发送心跳
+func Client(conn net.Conn) *Session {
+	s := newSession(conn, true)
+	go s.keepAlive()
+	return s
+}
+
+// Server 在已建立的连接上创建服务端一侧的会话
+func Server(conn net.Conn) *Session {
+	return newSession(conn, false)
+}
+
+func newSession(conn net.Conn, client bool) *Session {
+	s := &Session{
+		conn:    conn,
+		client:  client,
+		streams: make(map[uint32]*Stream),
+		nextID:  1,
+		accepts: make(chan *Stream, acceptBacklog),
+		done:    make(chan struct{}),
+	}
+	go s.recvLoop()
+	return s
+}
+
+// Open 打开一条新流，只能在客户端一侧调用
+func (s *Session) Open() (*Stream, error) {
+	if !s.client {
+		return nil, errors.New("只有客户端可以打开流")
+	}
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return nil, err
+	}
+	if len(s.streams) >= maxStreams {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("隧道中的流已达上限 %d", maxStreams)
+	}
+	stream := newStream(s, s.nextID)
+	s.streams[stream.id] = stream
+	s.nextID += 2
+	s.mu.Unlock()
+
+	if err := s.writeFrame(frameOpen, stream.id, 0, nil); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept 等待客户端打开的下一条流，会话结束后返回结束的原因
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-s.accepts:
+		return stream, nil
+	case <-s.done:
+		return nil, s.Err()
+	}
+}
+
+// Close 关闭会话和其中的全部流
+func (s *Session) Close() error {
+	s.closeWith(net.ErrClosed)
+	return nil
+}
+
+// Done 在会话结束时关闭
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err 返回会话结束的原因，会话仍在运行时返回 nil
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// NumStreams 返回当前打开的流数
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+func (s *Session) closeWith(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		streams := make([]*Stream, 0, len(s.streams))
+		for _, stream := range s.streams {
+			streams = append(streams, stream)
+		}
+		s.mu.Unlock()
+
+		s.conn.Close()
+		for _, stream := range streams {
+			stream.remoteClose()
+		}
+		close(s.done)
+	})
+}
+
+func (s *Session) writeFrame(kind byte, id uint32, length uint32, payload []byte) error {
+	frame := make([]byte, headerSize, headerSize+len(payload))
+	frame[0] = kind
+	binary.BigEndian.PutUint32(frame[1:], id)
+	binary.BigEndian.PutUint32(frame[5:], length)
+	frame = append(frame, payload...)
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	select {
+	case <-s.done:
+		return s.Err()
+	default:
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(frameWriteTimeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		s.closeWith(err)
+		return err
+	}
+	return nil
+}
+
+func (s *Session) recvLoop() {
+	header := make([]byte, headerSize)
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if _, err := io.ReadFull(s.conn, header); err != nil {
+			s.closeWith(err)
+			return
+		}
+		kind := header[0]
+		id := binary.BigEndian.Uint32(header[1:])
+		length := binary.BigEndian.Uint32(header[5:])
+
+		switch kind {
+		case frameData:
+			if length > maxFrameData {
+				s.closeWith(fmt.Errorf("%w: 数据帧长度 %d 超过上限", errProtocol, length))
+				return
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(s.conn, payload); err != nil {
+				s.closeWith(err)
+				return
+			}
+			// 已关闭的流上迟到的数据直接丢弃
+			if stream := s.stream(id); stream != nil && !stream.receive(payload) {
+				s.closeWith(fmt.Errorf("%w: 流 %d 超出接收窗口", errProtocol, id))
+				return
+			}
+		case frameOpen:
+			if s.client {
+				s.closeWith(fmt.Errorf("%w: 服务端不能打开流", errProtocol))
+				return
+			}
+			s.acceptStream(id)
+		case frameWindow:
+			if stream := s.stream(id); stream != nil {
+				stream.addWindow(length)
+			}
+		case frameClose:
+			if stream := s.stream(id); stream != nil {
+				stream.remoteClose()
+			}
+		case framePing:
+			go s.writeFrame(framePong, 0, length, nil)
+		case framePong:
+		default:
+			s.closeWith(fmt.Errorf("%w: 未知的帧类型 %d", errProtocol, kind))
+			return
+		}
+	}
+}
+
+func (s *Session) stream(id uint32) *Stream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streams[id]
+}
+
+func (s *Session) acceptStream(id uint32) {
+	s.mu.Lock()
+	if _, exists := s.streams[id]; exists || len(s.streams) >= maxStreams {
+		s.mu.Unlock()
+		go s.writeFrame(frameClose, id, 0, nil)
+		return
+	}
+	stream := newStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	select {
+	case s.accepts <- stream:
+	default:
+		stream.Close()
+	}
+}
+
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	var seq uint32
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			seq++
+			if s.writeFrame(framePing, 0, seq, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Stream 是隧道中的一条流，实现 net.Conn，地址为隧道连接的地址
+type Stream struct {
+	id      uint32
+	session *Session
+
+	mu            sync.Mutex
+	buf           bytes.Buffer
+	unacked       uint32 // 已读出但尚未归还给对端的窗口
+	sendWindow    uint32
+	closed        bool // 本端已关闭
+	remoteClosed  bool // 对端已关闭或会话已结束
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	readable chan struct{}
+	writable chan struct{}
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{
+		id:         id,
+		session:    s,
+		sendWindow: streamWindow,
+		readable:   make(chan struct{}, 1),
+		writable:   make(chan struct{}, 1),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// receive 保存对端发来的数据，超出接收窗口时返回 false
+func (st *Stream) receive(payload []byte) bool {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return true
+	}
+	if st.buf.Len()+len(payload) > streamWindow {
+		st.mu.Unlock()
+		return false
+	}
+	st.buf.Write(payload)
+	st.mu.Unlock()
+	notify(st.readable)
+	return true
+}
+
+func (st *Stream) addWindow(n uint32) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.mu.Unlock()
+	notify(st.writable)
+}
+
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.remoteClosed = true
+	st.mu.Unlock()
+	notify(st.readable)
+	notify(st.writable)
+}
+
+// wait 等待 ch 的通知、deadline 到期或会话结束
+func (st *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-ch:
+	case <-st.session.done:
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// Read 读取对端发来的数据，对端关闭后读完剩余数据再返回 io.EOF
+func (st *Stream) Read(b []byte) (int, error) {
+	for {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if st.buf.Len() > 0 {
+			n, _ := st.buf.Read(b)
+			st.unacked += uint32(n)
+			var ack uint32
+			if st.unacked >= streamWindow/2 {
+				ack, st.unacked = st.unacked, 0
+			}
+			remoteClosed := st.remoteClosed
+			st.mu.Unlock()
+			if ack > 0 && !remoteClosed {
+				st.session.writeFrame(frameWindow, st.id, ack, nil)
+			}
+			return n, nil
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := st.readDeadline
+		st.mu.Unlock()
+
+		if err := st.wait(st.readable, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write 在发送窗口允许的范围内分帧发送，窗口用完时等待对端读取
+func (st *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		}
+		if st.remoteClosed {
+			st.mu.Unlock()
+			return written, io.ErrClosedPipe
+		}
+		if st.sendWindow == 0 {
+			deadline := st.writeDeadline
+			st.mu.Unlock()
+			if err := st.wait(st.writable, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(len(b), int(st.sendWindow), maxFrameData)
+		st.sendWindow -= uint32(n)
+		st.mu.Unlock()
+
+		if err := st.session.writeFrame(frameData, st.id, uint32(n), b[:n]); err != nil {
+			return written, err
+		}
+		written += n
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Close 关闭流并通知对端，未读的数据被丢弃
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	remoteClosed := st.remoteClosed
+	st.buf.Reset()
+	st.mu.Unlock()
+	notify(st.readable)
+	notify(st.writable)
+
+	st.session.removeStream(st.id)
+	if !remoteClosed {
+		st.session.writeFrame(frameClose, st.id, 0, nil)
+	}
+	return nil
+}
+
+func (st *Stream) LocalAddr() net.Addr {
+	return st.session.conn.LocalAddr()
+}
+
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.session.conn.RemoteAddr()
+}
+
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	return st.SetWriteDeadline(t)
+}
+
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	notify(st.readable)
+	return nil
+}
+
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	notify(st.writable)
+	return nil
+}
@@ -0,0 +1,182 @@
+package _type
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type PacketType int32
+
+const (
+	PacketTick                    PacketType = 10
+	PacketGameCommand             PacketType = 20
+	PacketSyncChecksum            PacketType = 30
+	PacketSyncChecksumStatus      PacketType = 31
+	PacketSync                    PacketType = 35
+	PacketServerInfo              PacketType = 106
+	PacketHeartBeat               PacketType = 108
+	PacketHeartBeatResponse       PacketType = 109
+	PacketRegisterPlayer          PacketType = 110
+	PacketDisconnect              PacketType = 111
+	PacketAcceptStartGame         PacketType = 112
+	PacketPasswdError             PacketType = 113
+	PacketTeamList                PacketType = 115
+	PacketQuestion                PacketType = 117
+	PacketQuestionResponse        PacketType = 118
+	PacketStartGame               PacketType = 120
+	PacketChatReceive             PacketType = 140
+	PacketChat                    PacketType = 141
+	PacketKick                    PacketType = 150
+	PacketPreregisterInfo         PacketType = 160
+	PacketPreregisterInfoResponse PacketType = 161
+	PacketReconnectTo             PacketType = 178
+)
+
+type Direction int
+
+const (
+	DirectionUnknown Direction = iota
+	DirectionClientToServer
+	DirectionServerToClient
+	DirectionBoth
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionClientToServer:
+		return "C->S"
+	case DirectionServerToClient:
+		return "S->C"
+	case DirectionBoth:
+		return "C<->S"
+	default:
+		return "?"
+	}
+}
+
+type PacketDecoder func(packet Packet) (interface{}, error)
+type PacketEncoder func(value interface{}) (Packet, error)
+
+type PacketInfo struct {
+	Type      PacketType
+	Name      string
+	Direction Direction
+	Decode    PacketDecoder
+	Encode    PacketEncoder
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[PacketType]*PacketInfo{}
+)
+
+func init() {
+	for _, info := range []PacketInfo{
+		{Type: PacketTick, Name: "TICK", Direction: DirectionServerToClient},
+		{Type: PacketGameCommand, Name: "GAMECOMMAND", Direction: DirectionClientToServer},
+		{Type: PacketSyncChecksum, Name: "SYNC_CHECKSUM", Direction: DirectionBoth},
+		{Type: PacketSyncChecksumStatus, Name: "SYNC_CHECKSUM_STATUS", Direction: DirectionBoth},
+		{Type: PacketSync, Name: "SYNC", Direction: DirectionBoth},
+		{Type: PacketServerInfo, Name: "SERVER_INFO", Direction: DirectionServerToClient},
+		{Type: PacketHeartBeat, Name: "HEART_BEAT", Direction: DirectionServerToClient},
+		{Type: PacketHeartBeatResponse, Name: "HEART_BEAT_RESPONSE", Direction: DirectionClientToServer},
+		{Type: PacketRegisterPlayer, Name: "REGISTER_PLAYER", Direction: DirectionClientToServer},
+		{Type: PacketDisconnect, Name: "DISCONNECT", Direction: DirectionBoth},
+		{Type: PacketAcceptStartGame, Name: "ACCEPT_START_GAME", Direction: DirectionClientToServer},
+		{Type: PacketPasswdError, Name: "PASSWD_ERROR", Direction: DirectionServerToClient},
+		{Type: PacketTeamList, Name: "TEAM_LIST", Direction: DirectionServerToClient},
+		{Type: PacketQuestion, Name: "QUESTION", Direction: DirectionServerToClient},
+		{Type: PacketQuestionResponse, Name: "QUESTION_RESPONSE", Direction: DirectionClientToServer},
+		{Type: PacketStartGame, Name: "START_GAME", Direction: DirectionServerToClient},
+		{Type: PacketChatReceive, Name: "CHAT_RECEIVE", Direction: DirectionClientToServer},
+		{Type: PacketChat, Name: "CHAT", Direction: DirectionServerToClient},
+		{Type: PacketKick, Name: "KICK", Direction: DirectionServerToClient},
+		{Type: PacketPreregisterInfo, Name: "PREREGISTER_INFO", Direction: DirectionClientToServer},
+		{Type: PacketPreregisterInfoResponse, Name: "PREREGISTER_INFO_RESPONSE", Direction: DirectionServerToClient},
+		{Type: PacketReconnectTo, Name: "RECONNECT_TO", Direction: DirectionServerToClient},
+	} {
+		Register(info)
+	}
+}
+
+// Register 注册或覆盖一个包类型的描述
+func Register(info PacketInfo) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	entry := info
+	registry[info.Type] = &entry
+}
+
+// RegisterCodec 为已知包类型挂载解码/编码函数，未知类型会以 UNKNOWN 名称登记
+func RegisterCodec(packetType PacketType, decode PacketDecoder, encode PacketEncoder) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	entry, ok := registry[packetType]
+	if !ok {
+		entry = &PacketInfo{Type: packetType, Name: "UNKNOWN", Direction: DirectionUnknown}
+		registry[packetType] = entry
+	}
+	entry.Decode = decode
+	entry.Encode = encode
+}
+
+func Lookup(packetType PacketType) (PacketInfo, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	entry, ok := registry[packetType]
+	if !ok {
+		return PacketInfo{}, false
+	}
+	return *entry, true
+}
+
+func LookupByName(name string) (PacketInfo, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, entry := range registry {
+		if entry.Name == name {
+			return *entry, true
+		}
+	}
+	return PacketInfo{}, false
+}
+
+// Registered 按包ID升序返回全部已登记的包类型
+func Registered() []PacketInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	result := make([]PacketInfo, 0, len(registry))
+	for _, entry := range registry {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Type < result[j].Type })
+	return result
+}
+
+func (t PacketType) Name() string {
+	if info, ok := Lookup(t); ok {
+		return info.Name
+	}
+	return "UNKNOWN"
+}
+
+func (t PacketType) String() string {
+	return fmt.Sprintf("%s(%d)", t.Name(), int32(t))
+}
+
+func (t PacketType) Decode(packet Packet) (interface{}, error) {
+	info, ok := Lookup(t)
+	if !ok || info.Decode == nil {
+		return nil, fmt.Errorf("%v 没有注册解码器", t)
+	}
+	return info.Decode(packet)
+}
+
+func (t PacketType) Encode(value interface{}) (Packet, error) {
+	info, ok := Lookup(t)
+	if !ok || info.Encode == nil {
+		return Packet{}, fmt.Errorf("%v 没有注册编码器", t)
+	}
+	return info.Encode(value)
+}
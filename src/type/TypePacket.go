@@ -1,6 +1,6 @@
 package _type
 
 type Packet struct {
-	Type  int32
+	Type  PacketType
 	Bytes []byte
 }
@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+)
+
+const bridgeUsage = `用法: shadowplayer bridge -url <ws(s)://地址/路径> [选项]
+
+在本机监听游戏连接，每个连接通过一条 WebSocket 转发到 ShadowPlayer 服务器，
+适用于只能访问 HTTP(S) 的网络。在游戏中连接 -listen 指定的地址即可。
+
+选项:
+`
+
+const bridgeDialTimeout = 10 * time.Second
+
+// RunBridge 执行 bridge 子命令，返回进程退出码
+func RunBridge(args []string) int {
+	flags := flag.NewFlagSet("bridge", flag.ContinueOnError)
+	listen := flags.String("listen", "127.0.0.1:5123", "本地监听地址，游戏中连接该地址")
+	rawURL := flags.String("url", "", "ShadowPlayer 服务器的 WebSocket 地址，例如 wss://example.com/ws（必填）")
+	mode := flags.String("mode", "frame", "frame: 每条消息一个游戏帧；stream: 按字节流发送，不对齐帧边界")
+	caFile := flags.String("ca", "", "校验 wss 服务器证书的 CA 文件，留空时使用系统根证书")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), bridgeUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if *rawURL == "" || flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var subprotocol string
+	switch *mode {
+	case "frame":
+		subprotocol = ProtocolFrame
+	case "stream":
+		subprotocol = ProtocolStream
+	default:
+		fmt.Fprintf(os.Stderr, "未知的模式: %s\n", *mode)
+		return 2
+	}
+
+	var tlsConfig *tls.Config
+	if *caFile != "" {
+		pem, err := os.ReadFile(*caFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取 CA 文件失败: %v\n", err)
+			return 1
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Fprintf(os.Stderr, "CA 文件中没有有效的证书: %s\n", *caFile)
+			return 1
+		}
+		tlsConfig = &tls.Config{RootCAs: pool}
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "监听 %s 失败: %v\n", *listen, err)
+		return 1
+	}
+	defer listener.Close()
+
+	slog.Info("正在监听", "listen", listener.Addr().String(), "url", *rawURL)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "接受连接失败: %v\n", err)
+			return 1
+		}
+		go bridge(conn, *rawURL, subprotocol, tlsConfig)
+	}
+}
+
+func bridge(conn net.Conn, rawURL string, subprotocol string, tlsConfig *tls.Config) {
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), bridgeDialTimeout)
+	ws, selected, err := Dial(ctx, rawURL, []string{subprotocol}, tlsConfig)
+	cancel()
+	if err != nil {
+		slog.Warn("连接 WebSocket 服务器失败", "err", err)
+		return
+	}
+	remote := NewNetConn(ws, selected)
+	defer remote.Close()
+	slog.Info("游戏连接已接入 WebSocket", "client", conn.RemoteAddr().String())
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, remote)
+		done <- struct{}{}
+	}()
+	<-done
+	slog.Info("游戏连接已结束", "client", conn.RemoteAddr().String())
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// 子协议决定写入时如何把字节流切分成消息，读取时两者相同，依次返回各条消息的内容
+const (
+	ProtocolFrame  = "shadowplayer.frame"  // 每条二进制消息恰好是一个游戏帧（长度 + 类型 + 内容）
+	ProtocolStream = "shadowplayer.stream" // 二进制消息只是字节流的片段，不对齐帧边界
+)
+
+// Subprotocols 为服务端支持的子协议，客户端未指定时按 ProtocolFrame 处理
+var Subprotocols = []string{ProtocolFrame, ProtocolStream}
+
+const frameHeaderSize = 8
+
+// NetConn 把 WebSocket 连接适配为 net.Conn，使其可以像 TCP 连接一样交给会话处理
+type NetConn struct {
+	ws     *Conn
+	framed bool
+
+	readBuf []byte
+
+	writeMu sync.Mutex
+	pending []byte // 按帧发送时尚未凑成完整帧的数据
+}
+
+// NewNetConn 按协商出的子协议适配连接，subprotocol 为空时按帧发送
+func NewNetConn(ws *Conn, subprotocol string) *NetConn {
+	return &NetConn{ws: ws, framed: subprotocol != ProtocolStream}
+}
+
+// Read 返回收到的二进制消息的内容，文本消息被忽略
+func (c *NetConn) Read(b []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		opcode, message, err := c.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if opcode == OpBinary {
+			c.readBuf = message
+		}
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// Write 按帧发送时把数据攒成完整的游戏帧再逐帧发出，否则每次写入发送一条消息
+func (c *NetConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.framed {
+		if err := c.ws.WriteMessage(OpBinary, b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	c.pending = append(c.pending, b...)
+	for len(c.pending) >= frameHeaderSize {
+		length := int32(binary.BigEndian.Uint32(c.pending))
+		if length < 0 {
+			return 0, fmt.Errorf("游戏帧长度无效: %d", length)
+		}
+		size := frameHeaderSize + int(length)
+		if len(c.pending) < size {
+			break
+		}
+		if err := c.ws.WriteMessage(OpBinary, c.pending[:size]); err != nil {
+			return 0, err
+		}
+		c.pending = c.pending[size:]
+	}
+	// 缓冲区已全部发出时释放底层数组，避免长期持有大帧的内存
+	if len(c.pending) == 0 {
+		c.pending = nil
+	}
+	return len(b), nil
+}
+
+func (c *NetConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *NetConn) LocalAddr() net.Addr {
+	return c.ws.LocalAddr()
+}
+
+func (c *NetConn) RemoteAddr() net.Addr {
+	return c.ws.RemoteAddr()
+}
+
+func (c *NetConn) SetDeadline(t time.Time) error {
+	c.ws.SetReadDeadline(t)
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *NetConn) SetReadDeadline(t time.Time) error {
+	return c.ws.SetReadDeadline(t)
+}
+
+func (c *NetConn) SetWriteDeadline(t time.Time) error {
+	return c.ws.SetWriteDeadline(t)
+}
@@ -0,0 +1,403 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RFC 6455 中的操作码
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize 是新连接的 MaxMessageSize：maxMessageSize 允许的最大游戏帧加上 8 字节帧头
+const DefaultMaxMessageSize = 64*1024*1024 + 8
+
+var errProtocol = errors.New("WebSocket 协议错误")
+
+// Conn 是一条已完成握手的 WebSocket 连接。ReadMessage 只能在一个协程中调用，
+// 收到的 ping 和关闭帧在读取时自动应答；WriteMessage 可以并发调用
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool // 客户端发出的帧必须加掩码
+
+	// MaxMessageSize 限制收到的单条消息的大小，默认为 DefaultMaxMessageSize，为 0 时不限制
+	MaxMessageSize int
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// chooseSubprotocol 按服务端的顺序选择双方都支持的子协议，没有时返回空字符串
+func chooseSubprotocol(header http.Header, supported []string) string {
+	for _, protocol := range supported {
+		if headerContains(header, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+// Upgrade 完成服务端握手并接管 HTTP 连接，返回协商出的子协议。
+// 请求不是有效的 WebSocket 握手时回复 400 并返回错误
+func Upgrade(w http.ResponseWriter, r *http.Request, subprotocols []string) (*Conn, string, error) {
+	fail := func(msg string) (*Conn, string, error) {
+		http.Error(w, msg, http.StatusBadRequest)
+		return nil, "", fmt.Errorf("%w: %s", errProtocol, msg)
+	}
+	if r.Method != http.MethodGet {
+		return fail("WebSocket 握手必须使用 GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail("不是 WebSocket 握手请求")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail("不支持的 WebSocket 版本")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail("Sec-WebSocket-Key 无效")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "连接不支持接管", http.StatusInternalServerError)
+		return nil, "", errors.New("http.ResponseWriter 不支持 Hijack")
+	}
+	subprotocol := chooseSubprotocol(r.Header, subprotocols)
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, "", err
+	}
+	// 清除 http.Server 为读取请求头设置的超时
+	conn.SetDeadline(time.Time{})
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	fmt.Fprintf(&response, "Sec-WebSocket-Accept: %s\r\n", acceptKey(key))
+	if subprotocol != "" {
+		fmt.Fprintf(&response, "Sec-WebSocket-Protocol: %s\r\n", subprotocol)
+	}
+	response.WriteString("\r\n")
+	if _, err := io.WriteString(conn, response.String()); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	return &Conn{conn: conn, reader: rw.Reader, MaxMessageSize: DefaultMaxMessageSize}, subprotocol, nil
+}
+
+// Dial 连接 ws:// 或 wss:// 地址并完成客户端握手，返回服务端选择的子协议。
+// tlsConfig 为 nil 时使用默认配置
+func Dial(ctx context.Context, rawURL string, subprotocols []string, tlsConfig *tls.Config) (*Conn, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	host := u.Host
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, "", fmt.Errorf("不支持的地址协议 %q，应为 ws 或 wss", u.Scheme)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, "", err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if u.Scheme == "wss" {
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, "", err
+		}
+		conn = tlsConn
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	var request strings.Builder
+	fmt.Fprintf(&request, "GET %s HTTP/1.1\r\nHost: %s\r\n", u.RequestURI(), u.Host)
+	request.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\n")
+	fmt.Fprintf(&request, "Sec-WebSocket-Key: %s\r\n", key)
+	if len(subprotocols) > 0 {
+		fmt.Fprintf(&request, "Sec-WebSocket-Protocol: %s\r\n", strings.Join(subprotocols, ", "))
+	}
+	request.WriteString("\r\n")
+	if _, err := io.WriteString(conn, request.String()); err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodGet})
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, "", fmt.Errorf("服务器拒绝 WebSocket 握手: %s", response.Status)
+	}
+	if response.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, "", fmt.Errorf("%w: Sec-WebSocket-Accept 不匹配", errProtocol)
+	}
+	conn.SetDeadline(time.Time{})
+	return &Conn{conn: conn, reader: reader, client: true, MaxMessageSize: DefaultMaxMessageSize}, response.Header.Get("Sec-WebSocket-Protocol"), nil
+}
+
+// ReadMessage 读取下一条数据消息，分片的消息会被拼接完整。
+// 对端关闭连接时返回 io.EOF
+func (c *Conn) ReadMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, frameOp, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch frameOp {
+		case OpPing:
+			if err := c.writeFrame(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeClose(payload)
+			return 0, nil, io.EOF
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, fmt.Errorf("%w: 意外的后续分片", errProtocol)
+			}
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, fmt.Errorf("%w: 上一条消息尚未结束", errProtocol)
+			}
+			opcode = frameOp
+		default:
+			return 0, nil, fmt.Errorf("%w: 未知的操作码 %d", errProtocol, frameOp)
+		}
+
+		if c.MaxMessageSize > 0 && len(message)+len(payload) > c.MaxMessageSize {
+			return 0, nil, fmt.Errorf("%w: 消息超过 %d 字节", errProtocol, c.MaxMessageSize)
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2, 8)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	if header[0]&0x70 != 0 {
+		err = fmt.Errorf("%w: 未协商扩展却设置了 RSV 位", errProtocol)
+		return
+	}
+	masked := header[1]&0x80 != 0
+	if masked == c.client {
+		err = fmt.Errorf("%w: 帧的掩码位不正确", errProtocol)
+		return
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(c.reader, header[:2]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(header))
+	case 127:
+		if _, err = io.ReadFull(c.reader, header[:8]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(header[:8])
+		if length>>63 != 0 {
+			err = fmt.Errorf("%w: 帧长度的最高位不为 0", errProtocol)
+			return
+		}
+	}
+	if opcode >= OpClose && (length > 125 || !fin) {
+		err = fmt.Errorf("%w: 控制帧过长或被分片", errProtocol)
+		return
+	}
+	if c.MaxMessageSize > 0 && length > uint64(c.MaxMessageSize) {
+		err = fmt.Errorf("%w: 帧超过 %d 字节", errProtocol, c.MaxMessageSize)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.reader, mask[:]); err != nil {
+			return
+		}
+	}
+	if c.MaxMessageSize > 0 {
+		payload = make([]byte, length)
+		_, err = io.ReadFull(c.reader, payload)
+	} else {
+		// 不限制大小时按实际收到的数据分配，不信任对端声明的长度
+		payload, err = io.ReadAll(io.LimitReader(c.reader, int64(length)))
+		if err == nil && uint64(len(payload)) < length {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	if err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// WriteMessage 以一个帧发送一条消息
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closeSent && opcode != OpClose {
+		return net.ErrClosed
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// writeClose 发送关闭帧，每条连接只发送一次
+func (c *Conn) writeClose(payload []byte) {
+	c.writeMu.Lock()
+	if c.closeSent {
+		c.writeMu.Unlock()
+		return
+	}
+	c.closeSent = true
+	c.writeMu.Unlock()
+
+	// 只回应状态码，不回应原因
+	if len(payload) > 2 {
+		payload = payload[:2]
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(OpClose, payload)
+}
+
+// Close 发送正常关闭的关闭帧后关闭底层连接
+func (c *Conn) Close() error {
+	c.writeClose(binary.BigEndian.AppendUint16(nil, 1000))
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}